@@ -0,0 +1,94 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Requests from workers are authenticated with an HMAC-SHA256 signature of
+// the method, path, timestamp and body, keyed with a secret shared by the
+// server and its workers. The secret itself is never sent, and the timestamp
+// limits how long a captured request can be replayed.
+
+const (
+	authTimestampHeader = "X-Evolver-Timestamp"
+	authSignatureHeader = "X-Evolver-Signature"
+	// authMaxClockSkew is how far a request timestamp may be from the server clock
+	authMaxClockSkew = time.Minute * 5
+)
+
+// SignRequest adds authentication headers to a request. body must be the
+// request body, or nil if there is none.
+func SignRequest(req *http.Request, body []byte, secret string) {
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set(authTimestampHeader, timestamp)
+	req.Header.Set(authSignatureHeader, authSignature(secret, req.Method, req.URL.RequestURI(), timestamp, body))
+}
+
+// VerifyRequest returns an error if a request isn't signed with the secret
+func VerifyRequest(req *http.Request, body []byte, secret string) error {
+	timestamp := req.Header.Get(authTimestampHeader)
+	signature := req.Header.Get(authSignatureHeader)
+	if timestamp == "" || signature == "" {
+		return fmt.Errorf("Missing authentication headers")
+	}
+	seconds, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return fmt.Errorf("Invalid timestamp '%v'", timestamp)
+	}
+	skew := time.Since(time.Unix(seconds, 0))
+	if skew > authMaxClockSkew || skew < -authMaxClockSkew {
+		return fmt.Errorf("Timestamp is %v away from server time", skew)
+	}
+	expected := authSignature(secret, req.Method, req.URL.RequestURI(), timestamp, body)
+	if !hmac.Equal([]byte(signature), []byte(expected)) {
+		return fmt.Errorf("Invalid signature")
+	}
+	return nil
+}
+
+// AuthMiddleware returns a gin handler that rejects requests that aren't
+// signed with the secret. The body has to be read to check the signature, so
+// bodies over maxBodySize bytes are rejected before they are buffered.
+func AuthMiddleware(secret string, maxBodySize int64) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		var body []byte
+		if ctx.Request.Body != nil {
+			var err error
+			body, err = ioutil.ReadAll(http.MaxBytesReader(ctx.Writer, ctx.Request.Body, maxBodySize))
+			if err != nil {
+				status := http.StatusBadRequest
+				if _, ok := err.(*http.MaxBytesError); ok {
+					status = http.StatusRequestEntityTooLarge
+				}
+				ctx.AbortWithStatusJSON(status, map[string]interface{}{"Message": err.Error()})
+				return
+			}
+			ctx.Request.Body = ioutil.NopCloser(bytes.NewReader(body))
+		}
+		err := VerifyRequest(ctx.Request, body, secret)
+		if err != nil {
+			log.Printf("Rejected %v %v from %v: %v", ctx.Request.Method, ctx.Request.URL.Path, ctx.ClientIP(), err.Error())
+			ctx.AbortWithStatusJSON(http.StatusUnauthorized, map[string]interface{}{"Message": err.Error()})
+			return
+		}
+		ctx.Next()
+	}
+}
+
+func authSignature(secret string, method string, uri string, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "%v\n%v\n%v\n", method, uri, timestamp)
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
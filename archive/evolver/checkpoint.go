@@ -0,0 +1,164 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Checkpoints are population files saved periodically while evolving. The
+// latest checkpoint is saved under the population filename, and copies of the
+// most recent ones are kept as <filename>.<iteration> so that evolution can
+// resume from an older checkpoint if the latest one is damaged.
+
+// SaveCheckpoint saves a population file as the latest checkpoint, and keeps
+// at most history older checkpoints next to it.
+func SaveCheckpoint(filename string, populationFile *PopulationFile, history int) error {
+	if history <= 0 {
+		return populationFile.Save(filename)
+	}
+	historyFilename := checkpointHistoryFilename(filename, populationFile.Header.Iteration)
+	err := populationFile.Save(historyFilename)
+	if err != nil {
+		return err
+	}
+	err = replaceWithCopy(historyFilename, filename)
+	if err != nil {
+		return err
+	}
+	pruneCheckpoints(filename, history)
+	return nil
+}
+
+// LoadCheckpoint loads the latest valid checkpoint. If the population file is
+// missing or damaged, older checkpoints are tried from newest to oldest. The
+// name of the file that was loaded is returned along with its contents.
+func LoadCheckpoint(filename string) (*PopulationFile, string, error) {
+	populationFile, err := LoadPopulationFile(filename)
+	if err == nil {
+		return populationFile, filename, nil
+	}
+	log.Printf("Error loading checkpoint %v: %v", filename, err.Error())
+	for _, historyFilename := range FindCheckpoints(filename) {
+		populationFile, historyErr := LoadPopulationFile(historyFilename)
+		if historyErr == nil {
+			log.Printf("Falling back to checkpoint %v", historyFilename)
+			return populationFile, historyFilename, nil
+		}
+		log.Printf("Error loading checkpoint %v: %v", historyFilename, historyErr.Error())
+	}
+	return nil, "", fmt.Errorf("No valid checkpoint found for %v: %v", filename, err.Error())
+}
+
+// FindCheckpointOrganism loads the organism with the hash from the population
+// file or one of its older checkpoints. It returns nil if none of them has it.
+// The organism is borrowed from objectPool.
+func FindCheckpointOrganism(filename string, hash string) *Organism {
+	for _, checkpoint := range append([]string{filename}, FindCheckpoints(filename)...) {
+		populationFile, err := LoadPopulationFile(checkpoint)
+		if err != nil {
+			continue
+		}
+		for _, data := range populationFile.Organisms {
+			organism := objectPool.BorrowOrganism()
+			organism.Load(data)
+			if organism.Hash() == hash {
+				return organism
+			}
+			objectPool.ReturnOrganism(organism)
+		}
+	}
+	return nil
+}
+
+// CheckpointExists returns true if there is a population file or any older
+// checkpoint for it
+func CheckpointExists(filename string) bool {
+	_, err := os.Stat(filename)
+	return err == nil || len(FindCheckpoints(filename)) > 0
+}
+
+// FindCheckpoints returns the older checkpoints of a population file, most
+// recently saved first. Iterations can go backwards after falling back to an
+// older checkpoint, so they aren't used for ordering.
+func FindCheckpoints(filename string) []string {
+	matches, _ := filepath.Glob(filename + ".*")
+	modified := map[string]time.Time{}
+	checkpoints := []string{}
+	for _, match := range matches {
+		_, err := strconv.Atoi(strings.TrimPrefix(match, filename+"."))
+		if err != nil {
+			continue
+		}
+		info, err := os.Stat(match)
+		if err != nil {
+			continue
+		}
+		modified[match] = info.ModTime()
+		checkpoints = append(checkpoints, match)
+	}
+	sort.SliceStable(checkpoints, func(i int, j int) bool {
+		return modified[checkpoints[i]].After(modified[checkpoints[j]])
+	})
+	return checkpoints
+}
+
+func checkpointHistoryFilename(filename string, iteration int) string {
+	return fmt.Sprintf("%v.%07d", filename, iteration)
+}
+
+// pruneCheckpoints deletes all but the newest history checkpoints
+func pruneCheckpoints(filename string, history int) {
+	checkpoints := FindCheckpoints(filename)
+	if len(checkpoints) <= history {
+		return
+	}
+	for _, checkpoint := range checkpoints[history:] {
+		err := os.Remove(checkpoint)
+		if err != nil {
+			log.Printf("Error removing old checkpoint %v: %v", checkpoint, err.Error())
+		}
+	}
+}
+
+// replaceWithCopy atomically replaces destination with a copy of source. A
+// hard link is used where the file system supports it.
+func replaceWithCopy(source string, destination string) error {
+	tempFilename := destination + ".tmp"
+	os.Remove(tempFilename)
+	if os.Link(source, tempFilename) != nil {
+		err := copyFile(source, tempFilename)
+		if err != nil {
+			os.Remove(tempFilename)
+			return err
+		}
+	}
+	return os.Rename(tempFilename, destination)
+}
+
+func copyFile(source string, destination string) error {
+	in, err := os.Open(source)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.Create(destination)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(out, in)
+	if err == nil {
+		err = out.Sync()
+	}
+	closeErr := out.Close()
+	if err == nil {
+		err = closeErr
+	}
+	return err
+}
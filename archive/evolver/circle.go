@@ -2,28 +2,54 @@ package main
 
 import (
 	"crypto/md5"
-	"encoding/base64"
 	"encoding/json"
 	"fmt"
 	"image/color"
+	"math"
 
 	"github.com/fogleman/gg"
+	pool "github.com/jolestar/go-commons-pool"
 )
 
+// TypeCircle is the type name for circles
 const TypeCircle = "circle"
 
+func init() {
+	RegisterInstructionType(&InstructionType{
+		Name:       TypeCircle,
+		NewFactory: func() pool.PooledObjectFactory { return NewCircleFactory() },
+		NewMutator: func(config *Config, imageWidth float32, imageHeight float32) InstructionMutator {
+			return NewCircleMutator(config, imageWidth, imageHeight)
+		},
+		ExportSVG: func(instruction Instruction) string {
+			circle := instruction.(*Circle)
+			return fmt.Sprintf(
+				"<circle cx=\"%v\" cy=\"%v\" r=\"%v\" fill=\"%v\"%v/>",
+				circle.X, circle.Y, circle.Radius, SaveColorHex(circle.Color),
+				svgOpacityAttribute("fill-opacity", circle.Opacity))
+		},
+		Merge: func(a Instruction, b Instruction) Instruction {
+			return a.(*Circle).Merge(b.(*Circle))
+		},
+	})
+}
+
+// Circle represents an instruction that draws a filled circle
 type Circle struct {
 	X          float32
 	Y          float32
 	Radius     float32
-	Color      color.Color `json:"-"`
-	SavedColor *SavedColor
+	Color      *color.RGBA `json:"-"`
+	SavedColor *SavedColor `json:",omitempty"`
+	HexColor   string      `json:",omitempty"`
+	Opacity    float32     // 0 is fully transparent, 1 is fully opaque
 	hash       string
+	bounds     Rect // Cache bounds
 }
 
 // Execute draws a circle at point
 func (circle *Circle) Execute(ctx *gg.Context) {
-	ctx.SetColor(circle.Color)
+	ctx.SetColor(ColorWithOpacity(circle.Color, circle.Opacity))
 	ctx.DrawCircle(float64(circle.X), float64(circle.Y), float64(circle.Radius))
 	ctx.Fill()
 }
@@ -33,18 +59,37 @@ func (circle *Circle) Scale(factor float32) Instruction {
 	clone.X *= factor
 	clone.Radius *= factor
 	clone.Y *= factor
+	clone.bounds = Rect{}
+	clone.hash = ""
+	return clone
+}
+
+// Translate returns a copy of the circle moved by dx, dy
+func (circle *Circle) Translate(dx float32, dy float32) Instruction {
+	clone := circle.Clone().(*Circle)
+	clone.X += dx
+	clone.Y += dy
+	clone.bounds = Rect{}
+	clone.hash = ""
 	return clone
 }
 
 func (circle *Circle) Save() []byte {
-	circle.SavedColor = SaveColor(circle.Color)
+	circle.HexColor = SaveColorHex(circle.Color)
+	circle.SavedColor = nil
 	data, _ := json.Marshal(circle)
 	return data
 }
 
 func (circle *Circle) Load(data []byte) {
+	circle.Opacity = legacyOpacity
 	json.Unmarshal(data, circle)
-	circle.Color = LoadColor(circle.SavedColor)
+	circle.Opacity = clampOpacity(circle.Opacity)
+	if circle.SavedColor != nil {
+		circle.Color = LoadColor(circle.SavedColor)
+	} else {
+		circle.Color = LoadColorHex(circle.HexColor)
+	}
 }
 
 func (circle *Circle) Type() string {
@@ -52,26 +97,66 @@ func (circle *Circle) Type() string {
 }
 
 func (circle *Circle) Clone() Instruction {
-	newCircle := *circle
-	return &newCircle
+	newCircle := objectPool.BorrowInstruction(TypeCircle).(*Circle)
+	// cheap deep copy of color
+	newColor := *circle.Color
+	newCircle.Color = &newColor
+	newCircle.HexColor = circle.HexColor
+	newCircle.Opacity = circle.Opacity
+	newCircle.SavedColor = circle.SavedColor
+	newCircle.X = circle.X
+	newCircle.Y = circle.Y
+	newCircle.Radius = circle.Radius
+	newCircle.hash = circle.hash
+	newCircle.bounds = circle.bounds
+	return newCircle
+}
+
+// Merge returns a new circle between the two circles, large enough to
+// cover the larger of them, in their average color
+func (circle *Circle) Merge(other *Circle) *Circle {
+	merged := circle.Clone().(*Circle)
+	merged.X = (circle.X + other.X) / 2
+	merged.Y = (circle.Y + other.Y) / 2
+	merged.Radius = float32(math.Max(float64(circle.Radius), float64(other.Radius)))
+	merged.Color = MixColors(circle.Color, other.Color)
+	merged.Opacity = (circle.Opacity + other.Opacity) / 2
+	merged.RecalculateHash()
+	return merged
 }
 
 func (circle *Circle) Hash() string {
 	if circle.hash == "" {
-		r, g, b, _ := circle.Color.RGBA()
-		value := fmt.Sprintf("%v%v%v%v%v%v", circle.X, circle.Y, circle.Radius, r, g, b)
-		hasher := md5.New()
-		circle.hash = base64.StdEncoding.EncodeToString(hasher.Sum([]byte(value)))
+		buf := objectPool.BorrowByteBuffer()
+		buf.WriteString(SaveColorHex(circle.Color))
+		buf.WriteString("|")
+		if circle.Opacity < 1 {
+			buf.WriteString(fmt.Sprintf("%.4f|", circle.Opacity))
+		}
+		buf.WriteString(fmt.Sprintf("%.4f|%.4f|%.4f|", circle.X, circle.Y, circle.Radius))
+		circle.hash = fmt.Sprintf("%x", md5.Sum(buf.Bytes()))
+		objectPool.ReturnByteBuffer(buf)
 	}
 	return circle.hash
 }
 
+// RecalculateHash clears the cached hash and bounds after the circle has been modified
+func (circle *Circle) RecalculateHash() {
+	circle.hash = ""
+	circle.bounds = Rect{}
+	circle.Hash()
+}
+
 // Bounds returns the rectangular bounds of the circle
 func (circle *Circle) Bounds() Rect {
-	return Rect{
+	if circle.bounds != (Rect{}) {
+		return circle.bounds
+	}
+	circle.bounds = Rect{
 		Left:   circle.X - circle.Radius,
 		Right:  circle.X + circle.Radius,
 		Top:    circle.Y - circle.Radius,
 		Bottom: circle.Y + circle.Radius,
 	}
+	return circle.bounds
 }
@@ -26,7 +26,7 @@ func (mut *CircleMutator) MutateInstruction(instruction Instruction) {
 	// color
 	// coordinates
 	// radius
-	switch rand.Int31n(3) {
+	switch chooseWeighted(mut.config.ColorMutationWeight, mut.config.CoordinateMutationWeight, mut.config.ShapeMutationWeight) {
 	case 0:
 		// color
 		mut.mutateColor(circle)
@@ -37,6 +37,7 @@ func (mut *CircleMutator) MutateInstruction(instruction Instruction) {
 		// radius
 		mut.mutateCircleRadius(circle)
 	}
+	circle.RecalculateHash()
 }
 
 func (mut *CircleMutator) InstructionType() string {
@@ -48,11 +49,13 @@ func (mut *CircleMutator) InstructionType() string {
 // Red, Green, Blue
 
 func (mut *CircleMutator) mutateColor(circle *Circle) {
-	switch rand.Int31n(3) {
+	switch rand.Int31n(4) {
 	case 0:
 		mut.mutateHue(circle)
 	case 1:
 		mut.mutateSaturation(circle)
+	case 2:
+		mutateOpacity(&circle.Opacity, mut.config)
 	default:
 		mut.mutateLightness(circle)
 	}
@@ -61,19 +64,19 @@ func (mut *CircleMutator) mutateColor(circle *Circle) {
 func (mut *CircleMutator) mutateHue(circle *Circle) {
 	hue, sat, lightness := MakeColor(circle.Color).Hsl()
 	newHue := mut.mutateValue(0, 360, mut.config.MinHueMutation, mut.config.MaxHueMutation, float32(hue))
-	circle.Color = LoadColor(SaveColor(colorful.Hsl(float64(newHue), sat, lightness)))
+	circle.Color = LoadColorHex(SaveColorHex(colorful.Hsl(float64(newHue), sat, lightness)))
 }
 
 func (mut *CircleMutator) mutateSaturation(circle *Circle) {
 	hue, sat, lightness := MakeColor(circle.Color).Hsl()
 	newSat := mut.mutateValue(0, 1, mut.config.MinSaturationMutation, mut.config.MaxSaturationMutation, float32(sat))
-	circle.Color = LoadColor(SaveColor(colorful.Hsl(hue, float64(newSat), lightness)))
+	circle.Color = LoadColorHex(SaveColorHex(colorful.Hsl(hue, float64(newSat), lightness)))
 }
 
 func (mut *CircleMutator) mutateLightness(circle *Circle) {
 	hue, sat, lightness := MakeColor(circle.Color).Hsl()
 	newLightness := mut.mutateValue(0, 1, mut.config.MinValueMutation, mut.config.MaxValueMutation, float32(lightness))
-	circle.Color = LoadColor(SaveColor(colorful.Hsl(hue, sat, float64(newLightness))))
+	circle.Color = LoadColorHex(SaveColorHex(colorful.Hsl(hue, sat, float64(newLightness))))
 }
 
 // Mutate Brush Size
@@ -95,17 +98,18 @@ func (mut *CircleMutator) mutateCoordinates(circle *Circle) {
 // Remove Instruction
 // Swap Instructions
 func (mut *CircleMutator) RandomInstruction() Instruction {
-	return &Circle{
-		X: rand.Float32() * mut.imageWidth,
-		Y: rand.Float32() * mut.imageHeight,
-		Color: &color.RGBA{
-			A: 255,
-			G: uint8(rand.Int31n(255)),
-			B: uint8(rand.Int31n(255)),
-			R: uint8(rand.Int31n(255)),
-		},
-		Radius: rand.Float32()*(mut.config.MaxCircleRadius-1) + 1,
+	circle := objectPool.BorrowInstruction(TypeCircle).(*Circle)
+	circle.X = rand.Float32() * mut.imageWidth
+	circle.Y = rand.Float32() * mut.imageHeight
+	circle.Color = &color.RGBA{
+		A: 255,
+		G: uint8(rand.Int31n(255)),
+		B: uint8(rand.Int31n(255)),
+		R: uint8(rand.Int31n(255)),
 	}
+	circle.Opacity = 1
+	circle.Radius = rand.Float32()*(mut.config.MaxCircleRadius-1) + 1
+	return circle
 }
 
 func (mut *CircleMutator) mutateValue(min float32, max float32, minDelta float32, maxDelta float32, value float32) float32 {
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"image"
 	"image/color"
 
 	colorful "github.com/lucasb-eyer/go-colorful"
@@ -61,6 +62,50 @@ func LoadColor(savedColor *SavedColor) *color.RGBA {
 	}
 }
 
+// AverageColor returns the average color of all pixels in the image, opaque
+func AverageColor(img image.Image) color.RGBA {
+	bounds := img.Bounds()
+	var r, g, b, count uint64
+	for x := bounds.Min.X; x < bounds.Max.X; x++ {
+		for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+			pr, pg, pb, _ := img.At(x, y).RGBA()
+			r += uint64(pr)
+			g += uint64(pg)
+			b += uint64(pb)
+			count++
+		}
+	}
+	if count == 0 {
+		return color.RGBA{A: 255}
+	}
+	return color.RGBA{
+		R: uint8(r / count >> 8),
+		G: uint8(g / count >> 8),
+		B: uint8(b / count >> 8),
+		A: 255,
+	}
+}
+
+// MixColors returns the average of two colors
+func MixColors(a *color.RGBA, b *color.RGBA) *color.RGBA {
+	return &color.RGBA{
+		uint8((uint16(a.R) + uint16(b.R)) / 2),
+		uint8((uint16(a.G) + uint16(b.G)) / 2),
+		uint8((uint16(a.B) + uint16(b.B)) / 2),
+		uint8(255),
+	}
+}
+
+// ColorWithOpacity returns the color with its alpha set from an opacity in [0, 1]
+func ColorWithOpacity(clr *color.RGBA, opacity float32) color.NRGBA {
+	return color.NRGBA{
+		R: clr.R,
+		G: clr.G,
+		B: clr.B,
+		A: uint8(opacity*255 + 0.5),
+	}
+}
+
 // LoadColorHex loads the color from a hex string
 func LoadColorHex(encoded string) *color.RGBA {
 	clr, _ := colorful.Hex(encoded)
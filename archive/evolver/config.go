@@ -15,6 +15,10 @@ type Config struct {
 	MaxValueMutation      float32
 	MinSaturationMutation float32
 	MaxSaturationMutation float32
+	MinAlphaMutation      float32
+	MaxAlphaMutation      float32
+	// Background
+	BackgroundMutationRate float32 // Fraction of mutations that change the background color instead of an instruction
 	// Coordinates
 	MinCoordinateMutation float32
 	MaxCoordinateMutation float32
@@ -36,18 +40,63 @@ type Config struct {
 	MaxPolygonAngleMutation  float32
 	MinPolygonPoints         int
 	MaxPolygonPoints         int
+	// Mutation weights. Operations are chosen in proportion to their weights.
+	AppendMutationWeight      float32 // Append a random instruction
+	DuplicateMutationWeight   float32 // Append a mutated duplicate of an instruction
+	DeleteMutationWeight      float32 // Delete an instruction
+	ReplaceMutationWeight     float32 // Replace an instruction with a mutated copy
+	SwapMutationWeight        float32 // Swap two instructions
+	ColorMutationWeight       float32 // Mutate the color of an instruction
+	CoordinateMutationWeight  float32 // Mutate the position of an instruction
+	ShapeMutationWeight       float32 // Mutate line width, circle radius or polygon points
+	MovePointMutationWeight   float32 // Move a polygon point, when mutating polygon points
+	RemovePointMutationWeight float32 // Remove a polygon point, when mutating polygon points
+	AddPointMutationWeight    float32 // Add a polygon point, when mutating polygon points
+	AdaptiveMutation          bool    // Scale operation weights by their recent success rate
+	AdaptiveMutationWindow    int     // Number of recent attempts per operation that success rates are measured over
+	AdaptiveMutationFloor     float32 // Added to success rates so that unsuccessful operations are still tried occasionally
 	// Other stuff
-	InstructionTypes      []string
-	ComplexityThreshold   int     // An organism can reach this many instructions before score penalties are applied
-	ComplexityPenalty     float32 // For each instruction over the threshold, this amount is added to the diff
-	MaxPopulation         int     // When repopulating, don't create more than this many organisms
-	MinComplexity         int     // Lower bound of default complexity when creating random organisms
-	MaxComplexity         int     // Upper bound of default complexity when creating random organisms
-	MinMutations          int     // Minimum number of mutations applied to an organism
-	MaxMutations          int     // Maximum number of mutations applied to an organism
-	WorkerCount           int     // At most this many workers. If less than or equal to zero, all cpus are applied to worker pool.
-	SyncFrequency         int     // Wait at most this many iterations before fetching top organisms from the server
-	OptimizationFrequency int     // Wait this many iterations before triggering an optimization run.
+	InstructionTypes       []string
+	ComplexityThreshold    int     // An organism can reach this many instructions before score penalties are applied
+	ComplexityPenalty      float32 // For each instruction over the threshold, this amount is added to the diff
+	MaxPopulation          int     // When repopulating, don't create more than this many organisms
+	MinComplexity          int     // Lower bound of default complexity when creating random organisms
+	MaxComplexity          int     // Upper bound of default complexity when creating random organisms
+	MinMutations           int     // Minimum number of mutations applied to an organism
+	MaxMutations           int     // Maximum number of mutations applied to an organism
+	WorkerCount            int     // At most this many workers. If less than or equal to zero, all cpus are applied to worker pool.
+	SyncFrequency          int     // Seconds between exports to the server, and between polls for top organisms when they can't be streamed
+	OptimizationFrequency  int     // Wait this many iterations before triggering an optimization run.
+	OptimizationTolerance  float32 // An optimization run keeps simplifications that worsen the diff by at most this much in total
+	OptimizationBatchSize  int     // Number of simplifications tried per iteration during an optimization run
+	OptimizationMerge      bool    // Optimization runs also try merging instructions into overlapping instructions of the same type
+	CheckpointHistory      int     // Number of older checkpoints kept next to the population file, named with their iteration
+	PatchJournalCompaction int     // Entries appended to the patch journal between compactions, which drop abandoned branches
+	SelectionStrategy      string  // How organisms survive each iteration: greedy, annealing or tournament
+	AnnealingTemperature   float32 // Initial annealing temperature, in units of average pixel diff
+	AnnealingCooling       float32 // The annealing temperature is multiplied by this after each iteration
+	TournamentPopulation   int     // Number of organisms retained between iterations by tournament selection
+	TournamentSize         int     // Number of retained organisms competing to be each child's parent
+	CrossoverRate          float32 // Fraction of children bred by crossover, when the selection strategy retains several parents (tournament)
+	FitnessMetric          string  // How rendered organisms are compared to the target: cie76, ciede2000, ssim, multiscale or edge
+	EdgeWeight             float32 // With the edge metric, pixels on the strongest edge count this much more than flat areas
+	// Pyramid
+	PyramidLevels    int     // The server evolves downsampled targets at this many resolutions before the full size target. 1 disables the pyramid.
+	PyramidScale     float32 // Size of each pyramid level relative to the next finer one
+	PlateauWindow    int     // A pyramid level ends when the diff improves by less than PlateauThreshold of itself over this many iterations
+	PlateauThreshold float32
+	// Tiles
+	TileSize        int // Width and height of the area each tile paints, in pixels
+	TileOverlap     int // Tiles are also scored against this many pixels of their neighbors, so that they blend at the seams
+	TileConcurrency int // Number of tiles evolved at once. If less than or equal to zero, one per cpu.
+	// Server
+	ListenAddress  string // Address the server listens on for workers
+	ListenPort     int    // Port the server listens on for workers
+	TLSCertFile    string // Certificate file the server uses to serve https. Requires TLSKeyFile.
+	TLSKeyFile     string // Private key file of TLSCertFile
+	TLSCAFile      string // Workers trust server certificates signed by the authority in this file, in addition to the system roots
+	AuthSecret     string // When set, workers sign every request with this secret and the server rejects unsigned requests
+	MaxRequestSize int64  // Largest request body in bytes the server reads to check its signature
 }
 
 // LoadConfig loads the application config from a file
@@ -88,6 +137,9 @@ func DefaultConfig() *Config {
 		MaxValueMutation:        0.1,
 		MinSaturationMutation:   0,
 		MaxSaturationMutation:   0.1,
+		MinAlphaMutation:        0,
+		MaxAlphaMutation:        0.1,
+		BackgroundMutationRate:  0.02,
 		MinCoordinateMutation:   0,
 		MaxCoordinateMutation:   100,
 		MinLineWidthMutation:    0,
@@ -106,6 +158,21 @@ func DefaultConfig() *Config {
 		MinPolygonPoints:         3,
 		MaxPolygonPoints:         7,
 
+		AppendMutationWeight:      1,
+		DuplicateMutationWeight:   1,
+		DeleteMutationWeight:      1,
+		ReplaceMutationWeight:     1,
+		SwapMutationWeight:        1,
+		ColorMutationWeight:       1,
+		CoordinateMutationWeight:  1,
+		ShapeMutationWeight:       1,
+		MovePointMutationWeight:   4,
+		RemovePointMutationWeight: 1,
+		AddPointMutationWeight:    1,
+		AdaptiveMutation:          false,
+		AdaptiveMutationWindow:    200,
+		AdaptiveMutationFloor:     0.01,
+
 		MaxLineLength:       50,
 		MaxLineArea:         250,
 		ComplexityThreshold: 10000,
@@ -120,5 +187,59 @@ func DefaultConfig() *Config {
 		},
 		WorkerCount:   0,
 		SyncFrequency: 50,
+		FitnessMetric: MetricCIE76,
+		EdgeWeight:    4,
+
+		OptimizationFrequency:  1000,
+		OptimizationTolerance:  0.001,
+		OptimizationBatchSize:  8,
+		OptimizationMerge:      false,
+		CheckpointHistory:      5,
+		PatchJournalCompaction: 10000,
+
+		SelectionStrategy:    SelectionGreedy,
+		AnnealingTemperature: 0.01,
+		AnnealingCooling:     0.999,
+		TournamentPopulation: 16,
+		TournamentSize:       3,
+		CrossoverRate:        0.2,
+
+		PyramidLevels:    1,
+		PyramidScale:     0.5,
+		PlateauWindow:    500,
+		PlateauThreshold: 0.005,
+
+		TileSize:        512,
+		TileOverlap:     32,
+		TileConcurrency: 0,
+
+		ListenAddress:  "0.0.0.0",
+		ListenPort:     8000,
+		MaxRequestSize: 16 << 20,
+	}
+}
+
+// ScaleConfig returns a copy of the config for a canvas scaled by factor, with
+// the sizes and distances that are measured in pixels scaled to match
+func ScaleConfig(config *Config, factor float32) *Config {
+	scaled := *config
+	for _, value := range []*float32{
+		&scaled.MinCoordinateMutation,
+		&scaled.MaxCoordinateMutation,
+		&scaled.MinLineWidthMutation,
+		&scaled.MaxLineWidthMutation,
+		&scaled.MaxLineWidth,
+		&scaled.MaxLineLength,
+		&scaled.MinCircleRadiusMutation,
+		&scaled.MaxCircleRadiusMutation,
+		&scaled.MaxCircleRadius,
+		&scaled.MinPolygonRadius,
+		&scaled.MaxPolygonRadius,
+		&scaled.MinPolygonRadiusMutation,
+		&scaled.MaxPolygonRadiusMutation,
+	} {
+		*value *= factor
 	}
+	scaled.MaxLineArea *= factor * factor
+	return &scaled
 }
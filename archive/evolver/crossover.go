@@ -0,0 +1,99 @@
+package main
+
+import "math/rand"
+
+// Regions swapped by crossover are between these fractions of the canvas size
+// in each dimension
+const minCrossoverRegion = 0.1
+const maxCrossoverRegion = 0.5
+
+// Crossover recombines two organisms. The child is the first parent with the
+// instructions in a random region of the canvas replaced by the second
+// parent's instructions in the same region. Instructions belong to a region if
+// their center is inside it.
+type Crossover struct {
+	imageWidth  float32
+	imageHeight float32
+}
+
+// NewCrossover returns a new `Crossover` for a canvas of the given size
+func NewCrossover(imageWidth float32, imageHeight float32) *Crossover {
+	crossover := new(Crossover)
+	crossover.imageWidth = imageWidth
+	crossover.imageHeight = imageHeight
+	return crossover
+}
+
+// RandomRegion returns a random region of the canvas to swap
+func (crossover *Crossover) RandomRegion() Rect {
+	width := crossover.imageWidth * (rand.Float32()*(maxCrossoverRegion-minCrossoverRegion) + minCrossoverRegion)
+	height := crossover.imageHeight * (rand.Float32()*(maxCrossoverRegion-minCrossoverRegion) + minCrossoverRegion)
+	left := rand.Float32() * (crossover.imageWidth - width)
+	top := rand.Float32() * (crossover.imageHeight - height)
+	return Rect{
+		Left:   left,
+		Top:    top,
+		Right:  left + width,
+		Bottom: top + height,
+	}
+}
+
+// Patch returns a patch that transforms `parent1` into the child of both
+// parents for the region. Instructions that both parents share are kept.
+// The patch target is not set, since it is only known after applying it.
+func (crossover *Crossover) Patch(parent1 *Organism, parent2 *Organism, region Rect) *Patch {
+	patch := objectPool.BorrowPatch()
+	patch.Baseline = parent1.Hash()
+	hashes1 := parent1.GetInstructionHashSet()
+	hashes2 := parent2.GetInstructionHashSet()
+	for _, instruction := range parent1.Instructions {
+		if crossover.inRegion(instruction, region) && !hashes2[instruction.Hash()] {
+			patch.Operations = append(patch.Operations, PatchOperation{
+				OperationType:    PatchOperationDelete,
+				InstructionHash1: instruction.Hash(),
+			})
+		}
+	}
+	// Appended instructions keep their order from the second parent, on top
+	// of the first parent's remaining instructions.
+	for _, instruction := range parent2.Instructions {
+		if crossover.inRegion(instruction, region) && !hashes1[instruction.Hash()] {
+			patch.Operations = append(patch.Operations, PatchOperation{
+				OperationType:   PatchOperationAppend,
+				InstructionData: instruction.Save(),
+				InstructionType: instruction.Type(),
+			})
+		}
+	}
+	objectPool.ReturnStringset(hashes1)
+	objectPool.ReturnStringset(hashes2)
+	return patch
+}
+
+// Breed creates a child of the two parents by swapping a random region. The
+// child carries the crossover patch, so it can be sent to workers like any
+// mutated organism. Nil is returned if the parents don't differ in the region.
+func (crossover *Crossover) Breed(parent1 *Organism, parent2 *Organism) *Organism {
+	patch := crossover.Patch(parent1, parent2, crossover.RandomRegion())
+	if len(patch.Operations) == 0 {
+		objectPool.ReturnPatch(patch)
+		return nil
+	}
+	child := parent1.Clone()
+	child.AffectedAreas = child.AffectedAreas[:0]
+	for _, operation := range patch.Operations {
+		child.AffectedAreas = append(child.AffectedAreas, operation.Apply(child)...)
+	}
+	child.hash = ""
+	patch.Target = child.Hash()
+	if child.Patch != nil {
+		objectPool.ReturnPatch(child.Patch)
+	}
+	child.Patch = patch
+	return child
+}
+
+func (crossover *Crossover) inRegion(instruction Instruction, region Rect) bool {
+	x, y := instruction.Bounds().Center()
+	return x >= region.Left && x < region.Right && y >= region.Top && y < region.Bottom
+}
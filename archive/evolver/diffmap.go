@@ -16,6 +16,17 @@ type DiffMap struct {
 	Total int64
 }
 
+// NewDiffMap returns a new, zeroed `DiffMap` for an image of the specified size
+func NewDiffMap(width int, height int) *DiffMap {
+	diffMap := &DiffMap{
+		Diffs: make([][]int64, width),
+	}
+	for x := 0; x < len(diffMap.Diffs); x++ {
+		diffMap.Diffs[x] = make([]int64, height)
+	}
+	return diffMap
+}
+
 // SetDiff updates the diff at the specified coordinates
 func (d *DiffMap) SetDiff(x int, y int, diff float32) {
 	newValue := int64(math.Ceil(float64(diff * granularity)))
@@ -34,8 +45,7 @@ func (d *DiffMap) GetDiff(x int, y int) float32 {
 func (d *DiffMap) GetAverageDiff() float32 {
 	width := len(d.Diffs)
 	height := len(d.Diffs[0])
-	avg := d.Total / int64(width*height)
-	return float32(avg) / granularity
+	return float32(float64(d.Total) / float64(width*height) / float64(granularity))
 }
 
 // Clear sets all pixel differences to zero.
@@ -22,13 +22,7 @@ func NewDiffMapFactory(width int, height int) *DiffMapFactory {
 
 // MakeObject creates new DiffMaps
 func (f *DiffMapFactory) MakeObject(ctx context.Context) (*pool.PooledObject, error) {
-	obj := &DiffMap{
-		Diffs: make([][]int64, f.width),
-	}
-	for x := 0; x < len(obj.Diffs); x++ {
-		obj.Diffs[x] = make([]int64, f.height)
-	}
-	return pool.NewPooledObject(obj), nil
+	return pool.NewPooledObject(NewDiffMap(f.width, f.height)), nil
 }
 
 // DestroyObject destroys objects
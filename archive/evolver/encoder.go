@@ -0,0 +1,50 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	// EncoderAuto - use a builtin encoder if one supports the output format,
+	// otherwise ffmpeg
+	EncoderAuto = "auto"
+	// EncoderBuiltin - encode in-process, without external programs
+	EncoderBuiltin = "builtin"
+	// EncoderFFmpeg - pipe the frames to ffmpeg, which must be installed
+	EncoderFFmpeg = "ffmpeg"
+)
+
+// A VideoEncoder writes a sequence of equally sized frames to a video file.
+// Close must be called to finish the file.
+type VideoEncoder interface {
+	AddFrame(frame image.Image) error
+	Close() error
+}
+
+// NewVideoEncoder returns an encoder for the output file. The builtin
+// encoders are chosen by extension: .gif for animated gifs, .png or .apng for
+// animated pngs and .avi for motion jpeg.
+func NewVideoEncoder(filename string, backend string, framesPerSecond int, quality int) (VideoEncoder, error) {
+	extension := strings.ToLower(filepath.Ext(filename))
+	if backend != EncoderFFmpeg {
+		switch extension {
+		case ".gif":
+			return NewGIFEncoder(filename, framesPerSecond)
+		case ".png", ".apng":
+			return NewAPNGEncoder(filename, framesPerSecond)
+		case ".avi":
+			return NewAVIEncoder(filename, framesPerSecond, quality)
+		}
+		if backend == EncoderBuiltin {
+			return nil, fmt.Errorf("No builtin encoder for '%v' files, use .gif, .png, .apng or .avi", extension)
+		}
+	}
+	if _, err := exec.LookPath("ffmpeg"); err != nil {
+		return nil, fmt.Errorf("'%v' files require ffmpeg, which wasn't found. Use .gif, .png, .apng or .avi instead", extension)
+	}
+	return NewFFmpegEncoder(filename, framesPerSecond)
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"image"
+	"image/png"
+	"io"
+	"os"
+)
+
+// pngSignature starts every png file
+var pngSignature = []byte("\x89PNG\r\n\x1a\n")
+
+// APNGEncoder writes an animated png that loops forever. Frames are encoded
+// with image/png and their image data is rewritten into animation chunks, so
+// they are written to disk as they are added.
+type APNGEncoder struct {
+	file            *os.File
+	framesPerSecond int
+	header          []byte // IHDR data of the first frame
+	actlOffset      int64
+	frames          int
+	sequence        uint32
+}
+
+// NewAPNGEncoder returns a new `APNGEncoder`
+func NewAPNGEncoder(filename string, framesPerSecond int) (*APNGEncoder, error) {
+	file, err := os.Create(filename)
+	if err != nil {
+		return nil, err
+	}
+	encoder := new(APNGEncoder)
+	encoder.file = file
+	encoder.framesPerSecond = framesPerSecond
+	return encoder, nil
+}
+
+func (encoder *APNGEncoder) AddFrame(frame image.Image) error {
+	buf := &bytes.Buffer{}
+	err := png.Encode(buf, opaqueFrame(frame))
+	if err != nil {
+		return err
+	}
+	header, data, err := readPNGChunks(buf.Bytes())
+	if err != nil {
+		return err
+	}
+	if encoder.header == nil {
+		encoder.header = header
+		chunks := &bytes.Buffer{}
+		chunks.Write(pngSignature)
+		writePNGChunk(chunks, "IHDR", header)
+		encoder.actlOffset = int64(chunks.Len())
+		// The number of frames is filled in when the file is closed
+		writePNGChunk(chunks, "acTL", make([]byte, 8))
+		_, err = encoder.file.Write(chunks.Bytes())
+		if err != nil {
+			return err
+		}
+	} else if !bytes.Equal(header, encoder.header) {
+		bounds := frame.Bounds()
+		return fmt.Errorf("Frame is %vx%v, but the first frame is %vx%v", bounds.Dx(), bounds.Dy(), binary.BigEndian.Uint32(encoder.header[0:4]), binary.BigEndian.Uint32(encoder.header[4:8]))
+	}
+
+	chunks := &bytes.Buffer{}
+	control := make([]byte, 26)
+	binary.BigEndian.PutUint32(control[0:4], encoder.nextSequence())
+	copy(control[4:12], header[0:8])
+	binary.BigEndian.PutUint16(control[20:22], 1)
+	binary.BigEndian.PutUint16(control[22:24], uint16(encoder.framesPerSecond))
+	writePNGChunk(chunks, "fcTL", control)
+	for _, chunk := range data {
+		if encoder.frames == 0 {
+			writePNGChunk(chunks, "IDAT", chunk)
+			continue
+		}
+		frameData := make([]byte, 4+len(chunk))
+		binary.BigEndian.PutUint32(frameData[0:4], encoder.nextSequence())
+		copy(frameData[4:], chunk)
+		writePNGChunk(chunks, "fdAT", frameData)
+	}
+	_, err = encoder.file.Write(chunks.Bytes())
+	if err != nil {
+		return err
+	}
+	encoder.frames++
+	return nil
+}
+
+func (encoder *APNGEncoder) Close() error {
+	err := encoder.finish()
+	closeErr := encoder.file.Close()
+	if err != nil {
+		return err
+	}
+	return closeErr
+}
+
+// finish ends the file and fills in the number of frames
+func (encoder *APNGEncoder) finish() error {
+	if encoder.frames == 0 {
+		return fmt.Errorf("No frames to encode")
+	}
+	err := writePNGChunk(encoder.file, "IEND", nil)
+	if err != nil {
+		return err
+	}
+	animation := make([]byte, 8)
+	binary.BigEndian.PutUint32(animation[0:4], uint32(encoder.frames))
+	chunk := &bytes.Buffer{}
+	writePNGChunk(chunk, "acTL", animation)
+	_, err = encoder.file.WriteAt(chunk.Bytes(), encoder.actlOffset)
+	return err
+}
+
+func (encoder *APNGEncoder) nextSequence() uint32 {
+	sequence := encoder.sequence
+	encoder.sequence++
+	return sequence
+}
+
+// readPNGChunks returns the IHDR data and the IDAT chunks of a png file
+func readPNGChunks(file []byte) ([]byte, [][]byte, error) {
+	if !bytes.HasPrefix(file, pngSignature) {
+		return nil, nil, fmt.Errorf("Not a png file")
+	}
+	var header []byte
+	data := [][]byte{}
+	for offset := len(pngSignature); offset+12 <= len(file); {
+		length := int(binary.BigEndian.Uint32(file[offset : offset+4]))
+		if offset+12+length > len(file) {
+			return nil, nil, fmt.Errorf("Truncated png chunk")
+		}
+		chunk := file[offset+8 : offset+8+length]
+		switch string(file[offset+4 : offset+8]) {
+		case "IHDR":
+			header = chunk
+		case "IDAT":
+			data = append(data, chunk)
+		}
+		offset += 12 + length
+	}
+	if header == nil || len(data) == 0 {
+		return nil, nil, fmt.Errorf("Incomplete png file")
+	}
+	return header, data, nil
+}
+
+func writePNGChunk(writer io.Writer, chunkType string, data []byte) error {
+	chunk := make([]byte, 8+len(data)+4)
+	binary.BigEndian.PutUint32(chunk[0:4], uint32(len(data)))
+	copy(chunk[4:8], chunkType)
+	copy(chunk[8:], data)
+	binary.BigEndian.PutUint32(chunk[8+len(data):], crc32.ChecksumIEEE(chunk[4:8+len(data)]))
+	_, err := writer.Write(chunk)
+	return err
+}
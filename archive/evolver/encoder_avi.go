@@ -0,0 +1,181 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"os"
+)
+
+// Offsets of the fields in the avi header that are only known once all
+// frames have been written
+const (
+	aviRiffSizeOffset         = 4
+	aviTotalFramesOffset      = 48
+	aviBufferSizeOffset       = 60
+	aviStreamLengthOffset     = 140
+	aviStreamBufferSizeOffset = 144
+	aviMoviSizeOffset         = 216
+	aviMoviOffset             = 220 // Index offsets are relative to the movi list type
+	aviHeaderSize             = 224
+)
+
+const (
+	// aviHasIndex flags a file as having an idx1 chunk
+	aviHasIndex = 0x10
+	// aviKeyFrame marks an index entry as a key frame, which every motion
+	// jpeg frame is
+	aviKeyFrame = 0x10
+)
+
+// AVIEncoder writes motion jpeg video in an avi container, which most players
+// support. Frames are written to disk as they are added.
+type AVIEncoder struct {
+	file            *os.File
+	framesPerSecond int
+	quality         int
+	width           int
+	height          int
+	offset          int64 // where the next frame is written
+	maxFrameSize    int
+	index           *bytes.Buffer
+	frames          int
+}
+
+// NewAVIEncoder returns a new `AVIEncoder`. quality is the jpeg quality of
+// the frames, from 1 to 100.
+func NewAVIEncoder(filename string, framesPerSecond int, quality int) (*AVIEncoder, error) {
+	file, err := os.Create(filename)
+	if err != nil {
+		return nil, err
+	}
+	encoder := new(AVIEncoder)
+	encoder.file = file
+	encoder.framesPerSecond = framesPerSecond
+	encoder.quality = quality
+	encoder.offset = aviHeaderSize
+	encoder.index = &bytes.Buffer{}
+	return encoder, nil
+}
+
+func (encoder *AVIEncoder) AddFrame(frame image.Image) error {
+	bounds := frame.Bounds()
+	if encoder.frames == 0 {
+		encoder.width = bounds.Dx()
+		encoder.height = bounds.Dy()
+		_, err := encoder.file.WriteAt(encoder.header(), 0)
+		if err != nil {
+			return err
+		}
+	} else if bounds.Dx() != encoder.width || bounds.Dy() != encoder.height {
+		return fmt.Errorf("Frame is %vx%v, but the first frame is %vx%v", bounds.Dx(), bounds.Dy(), encoder.width, encoder.height)
+	}
+	buf := &bytes.Buffer{}
+	buf.WriteString("00dc")
+	binary.Write(buf, binary.LittleEndian, uint32(0))
+	err := jpeg.Encode(buf, opaqueFrame(frame), &jpeg.Options{Quality: encoder.quality})
+	if err != nil {
+		return err
+	}
+	size := buf.Len() - 8
+	if size%2 == 1 {
+		// Chunks are word aligned
+		buf.WriteByte(0)
+	}
+	chunk := buf.Bytes()
+	binary.LittleEndian.PutUint32(chunk[4:8], uint32(size))
+	_, err = encoder.file.WriteAt(chunk, encoder.offset)
+	if err != nil {
+		return err
+	}
+	encoder.index.WriteString("00dc")
+	binary.Write(encoder.index, binary.LittleEndian, []uint32{aviKeyFrame, uint32(encoder.offset - aviMoviOffset), uint32(size)})
+	encoder.offset += int64(len(chunk))
+	if size > encoder.maxFrameSize {
+		encoder.maxFrameSize = size
+	}
+	encoder.frames++
+	return nil
+}
+
+func (encoder *AVIEncoder) Close() error {
+	err := encoder.finish()
+	closeErr := encoder.file.Close()
+	if err != nil {
+		return err
+	}
+	return closeErr
+}
+
+// finish writes the index and fills in the sizes in the header
+func (encoder *AVIEncoder) finish() error {
+	if encoder.frames == 0 {
+		return fmt.Errorf("No frames to encode")
+	}
+	index := &bytes.Buffer{}
+	index.WriteString("idx1")
+	binary.Write(index, binary.LittleEndian, uint32(encoder.index.Len()))
+	index.Write(encoder.index.Bytes())
+	_, err := encoder.file.WriteAt(index.Bytes(), encoder.offset)
+	if err != nil {
+		return err
+	}
+	fileSize := encoder.offset + int64(index.Len())
+	for offset, value := range map[int64]uint32{
+		aviRiffSizeOffset:         uint32(fileSize - 8),
+		aviTotalFramesOffset:      uint32(encoder.frames),
+		aviBufferSizeOffset:       uint32(encoder.maxFrameSize),
+		aviStreamLengthOffset:     uint32(encoder.frames),
+		aviStreamBufferSizeOffset: uint32(encoder.maxFrameSize),
+		aviMoviSizeOffset:         uint32(encoder.offset - aviMoviSizeOffset - 4),
+	} {
+		field := make([]byte, 4)
+		binary.LittleEndian.PutUint32(field, value)
+		_, err = encoder.file.WriteAt(field, offset)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// header returns the RIFF header, the stream description and the start of
+// the movi list, with the sizes that depend on the frames left empty
+func (encoder *AVIEncoder) header() []byte {
+	buf := &bytes.Buffer{}
+	write := func(values ...interface{}) {
+		for _, value := range values {
+			if fourCC, ok := value.(string); ok {
+				buf.WriteString(fourCC)
+			} else {
+				binary.Write(buf, binary.LittleEndian, value)
+			}
+		}
+	}
+	width := uint32(encoder.width)
+	height := uint32(encoder.height)
+	write("RIFF", uint32(0), "AVI ")
+	write("LIST", uint32(192), "hdrl")
+	// Main header: microseconds per frame, max bytes per second, padding,
+	// flags, total frames, initial frames, streams, buffer size, size and
+	// reserved fields
+	write("avih", uint32(56))
+	write(uint32(1000000/encoder.framesPerSecond), uint32(0), uint32(0), uint32(aviHasIndex))
+	write(uint32(0), uint32(0), uint32(1), uint32(0), width, height, [4]uint32{})
+	write("LIST", uint32(116), "strl")
+	// Stream header: type, handler, flags, priority and language, initial
+	// frames, scale, rate, start, length, buffer size, quality, sample size
+	// and frame rectangle
+	write("strh", uint32(56))
+	write("vids", "MJPG", uint32(0), uint32(0), uint32(0), uint32(1), uint32(encoder.framesPerSecond))
+	write(uint32(0), uint32(0), uint32(0), int32(-1), uint32(0))
+	write(uint16(0), uint16(0), uint16(width), uint16(height))
+	// Bitmap info header
+	write("strf", uint32(40))
+	write(uint32(40), width, height, uint16(1), uint16(24), "MJPG", width*height*3)
+	write(uint32(0), uint32(0), uint32(0), uint32(0))
+	write("LIST", uint32(0), "movi")
+	return buf.Bytes()
+}
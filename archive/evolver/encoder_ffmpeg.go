@@ -0,0 +1,56 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/png"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+)
+
+// FFmpegEncoder pipes png frames to ffmpeg, which picks the codec from the
+// extension of the output file
+type FFmpegEncoder struct {
+	ffmpeg *exec.Cmd
+	stdin  io.WriteCloser
+}
+
+// NewFFmpegEncoder starts ffmpeg and returns a new `FFmpegEncoder`
+func NewFFmpegEncoder(filename string, framesPerSecond int) (*FFmpegEncoder, error) {
+	encoder := new(FFmpegEncoder)
+	encoder.ffmpeg = exec.Command(
+		"ffmpeg",
+		"-y",
+		"-f",
+		"image2pipe",
+		"-framerate",
+		fmt.Sprint(framesPerSecond),
+		"-i",
+		"-",
+		filename,
+	)
+	encoder.ffmpeg.Stderr = os.Stderr
+	encoder.ffmpeg.Stdout = os.Stdout
+	stdin, err := encoder.ffmpeg.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	encoder.stdin = stdin
+	log.Printf("Running video encoder command...")
+	err = encoder.ffmpeg.Start()
+	if err != nil {
+		return nil, err
+	}
+	return encoder, nil
+}
+
+func (encoder *FFmpegEncoder) AddFrame(frame image.Image) error {
+	return png.Encode(encoder.stdin, opaqueFrame(frame))
+}
+
+func (encoder *FFmpegEncoder) Close() error {
+	encoder.stdin.Close()
+	return encoder.ffmpeg.Wait()
+}
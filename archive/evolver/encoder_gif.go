@@ -0,0 +1,53 @@
+package main
+
+import (
+	"image"
+	"image/color/palette"
+	"image/draw"
+	"image/gif"
+	"os"
+)
+
+// GIFEncoder writes an animated gif that loops forever. Frames are dithered
+// to a fixed palette and kept in memory until the file is closed, so it is
+// best suited to short videos.
+type GIFEncoder struct {
+	filename  string
+	delay     int // in 100ths of a second
+	animation *gif.GIF
+}
+
+// NewGIFEncoder returns a new `GIFEncoder`
+func NewGIFEncoder(filename string, framesPerSecond int) (*GIFEncoder, error) {
+	encoder := new(GIFEncoder)
+	encoder.filename = filename
+	encoder.delay = 100 / framesPerSecond
+	if encoder.delay < 2 {
+		// Most viewers slow down faster gifs to 10 frames per second
+		encoder.delay = 2
+	}
+	encoder.animation = &gif.GIF{}
+	return encoder, nil
+}
+
+func (encoder *GIFEncoder) AddFrame(frame image.Image) error {
+	bounds := frame.Bounds()
+	paletted := image.NewPaletted(image.Rect(0, 0, bounds.Dx(), bounds.Dy()), palette.Plan9)
+	draw.FloydSteinberg.Draw(paletted, paletted.Bounds(), frame, bounds.Min)
+	encoder.animation.Image = append(encoder.animation.Image, paletted)
+	encoder.animation.Delay = append(encoder.animation.Delay, encoder.delay)
+	return nil
+}
+
+func (encoder *GIFEncoder) Close() error {
+	file, err := os.Create(encoder.filename)
+	if err != nil {
+		return err
+	}
+	err = gif.EncodeAll(file, encoder.animation)
+	closeErr := file.Close()
+	if err != nil {
+		return err
+	}
+	return closeErr
+}
@@ -1,19 +1,23 @@
 package main
 
 import (
-	"bufio"
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"image"
+	"image/color"
 	"image/png"
 	"io/ioutil"
 	"log"
+	"math"
 	"math/rand"
+	"net"
 	"os"
-	"os/exec"
 	"runtime"
 	"runtime/pprof"
+	"sort"
+	"strconv"
 	"strings"
 	"time"
 
@@ -22,7 +26,6 @@ import (
 	kingpin "gopkg.in/alecthomas/kingpin.v2"
 )
 
-const maxImageDiff = 94.0
 const gcFrequency = 100
 
 var cwd, _ = os.Getwd()
@@ -39,23 +42,35 @@ var (
 	prof    = app.Flag("prof", "Enable profiling and write to specified file").String()
 	memprof = app.Flag("memprof", "Enable memory profiling and write to specified file").String()
 
+	authSecret = app.Flag("auth-secret", "Secret shared by the server and its workers to authenticate requests. Defaults to AuthSecret in config.json").Envar("EVOLVER_AUTH_SECRET").String()
+	tlsCAFile  = app.Flag("tls-ca", "Trust server certificates signed by the authority in this file. Defaults to TLSCAFile in config.json").String()
+
 	serverCmd        = app.Command("server", "Run a server process")
 	targetFile       = serverCmd.Arg("target", "File containing the target image").Required().String()
 	focusFile        = serverCmd.Flag("focus", "File containing a focus map").String()
 	serverMaxSeconds = serverCmd.Flag("max_seconds", "Maximum number of seconds to run").Int()
+	serverListen     = serverCmd.Flag("listen", "Address to listen on for workers. Defaults to ListenAddress in config.json").String()
+	serverPort       = serverCmd.Flag("port", "Port to listen on for workers. Defaults to ListenPort in config.json").Int()
+	serverTLSCert    = serverCmd.Flag("tls-cert", "Certificate file to serve https with. Defaults to TLSCertFile in config.json").String()
+	serverTLSKey     = serverCmd.Flag("tls-key", "Private key file of the certificate. Defaults to TLSKeyFile in config.json").String()
+	serverPyramid    = serverCmd.Flag("pyramid", "Number of resolutions to evolve at, starting from a downsampled target, before workers can join at full size. Coarse levels are only checkpointed if the server stops, after scaling to full size. Defaults to PyramidLevels in config.json").Int()
 
-	compareCmd   = app.Command("compare", "Compares two image files for difference and prints the result")
-	compareFile1 = compareCmd.Arg("file1", "First file to compare").Required().String()
-	compareFile2 = compareCmd.Arg("file2", "Second file to compare").Required().String()
+	compareCmd    = app.Command("compare", "Compares two image files for difference and prints the result")
+	compareFile1  = compareCmd.Arg("file1", "First file to compare").Required().String()
+	compareFile2  = compareCmd.Arg("file2", "Second file to compare").Required().String()
+	compareMetric = compareCmd.Flag("metric", "Fitness metric to compare with. Defaults to the metric in config.json").Enum(MetricCIE76, MetricCIEDE2000, MetricSSIM, MetricMultiScale, MetricEdgeWeighted)
 
 	workerCmd = app.Command("worker", "Run a worker process")
 	endpoint  = workerCmd.Arg("endpoint", "Endpoint of the server process").Required().String()
 
-	genvideoCmd          = app.Command("genvideo", "Generates an mp4 video file from a sequence of rendered organisms, showing the path of evolution to the final image (requires ffmpeg and linux).")
-	genvideoCmdPrefix    = genvideoCmd.Flag("prefix", "Prefix of the png files that will be used for the video").Required().String()
+	genvideoCmd          = app.Command("genvideo", "Generates a video file from a sequence of rendered organisms, showing the path of evolution to the final image. The format is chosen by the extension of the output file.")
+	genvideoCmdPrefix    = genvideoCmd.Flag("prefix", "Prefix of the png files that will be used for the video. Files are ordered by the number at the end of their name").Required().String()
 	genvideoCmdSourceDir = genvideoCmd.Flag("folder", "Folder containing the png files. Defaults to current working directory").Default(cwd).String()
 	genvideoCmdLength    = genvideoCmd.Flag("length", "The length of the video in seconds. The input files will be skipped in a time-lapse fashion to speed up the video to the desired duration (defaults to 60 seconds)").Default("60").Int()
-	genvideoCmdOutfile   = genvideoCmd.Flag("outfile", "Name of output video file").Default("video.mp4").String()
+	genvideoCmdOutfile   = genvideoCmd.Flag("outfile", "Name of output video file. .gif, .png, .apng and .avi files are encoded without external programs, other formats require ffmpeg").Default("video.avi").String()
+	genvideoCmdEncoder   = genvideoCmd.Flag("encoder", "Video encoder to use. auto uses ffmpeg only for formats without a builtin encoder").Default(EncoderAuto).Enum(EncoderAuto, EncoderBuiltin, EncoderFFmpeg)
+	genvideoCmdCrossfade = genvideoCmd.Flag("crossfade", "Number of blended frames to insert between consecutive files, for smoother videos from fewer files").Default("0").Int()
+	genvideoCmdQuality   = genvideoCmd.Flag("quality", "Jpeg quality of avi frames, from 1 to 100").Default("90").Int()
 
 	scaleCmd           = app.Command("scale", "Scales a population file by a specified factor")
 	scaleCmdFile       = scaleCmd.Flag("file", "Path to the population file to scale").Required().String()
@@ -65,15 +80,47 @@ var (
 	renderCmd           = app.Command("render", "Renders thie top organism from a population file")
 	renderCmdFile       = renderCmd.Flag("file", "Path to the population file to render").Required().String()
 	renderCmdOutputFile = renderCmd.Flag("output-file", "Path of the output file to create").Required().Short('o').String()
-	// TODO: embed image width and height in population file so that it can scale more sanely
-	renderCmdWidth  = renderCmd.Flag("width", "Width of output image in pixels").Short('w').Required().Int()
-	renderCmdHeight = renderCmd.Flag("height", "Height of output image in pixels").Short('h').Required().Int()
+	renderCmdWidth      = renderCmd.Flag("width", "Width of output image in pixels. Defaults to the width stored in the population file").Short('w').Int()
+	renderCmdHeight     = renderCmd.Flag("height", "Height of output image in pixels. Defaults to the height stored in the population file").Short('h').Int()
+
+	exportCmd           = app.Command("export", "Exports the top organism from a population file to a vector format")
+	exportCmdFile       = exportCmd.Flag("file", "Path to the population file to export").Required().String()
+	exportCmdOutputFile = exportCmd.Flag("output-file", "Path of the output file to create").Required().Short('o').String()
+	exportCmdFormat     = exportCmd.Flag("format", "Output format").Default("svg").Enum("svg")
+	exportCmdWidth      = exportCmd.Flag("width", "Width of output image in pixels. Defaults to the width stored in the population file").Short('w').Int()
+	exportCmdHeight     = exportCmd.Flag("height", "Height of output image in pixels. Defaults to the height stored in the population file").Short('h').Int()
 
 	downloadCmd      = app.Command("download", "Downloads a number of top organisms from the server and saves to a local file")
 	downloadEndpoint = downloadCmd.Flag("endpoint", "Endpoint of server to download from").Required().String()
 	downloadOutfile  = downloadCmd.Flag("outfile", "Output file to save downloaded organisms to").Required().String()
 	downloadCount    = downloadCmd.Flag("count", "Number of top organisms to download").Default("1").Int()
 
+	replayCmd                = app.Command("replay", "Replays the evolution recorded in a patch journal, rendering frames of it or exporting a historical organism")
+	replayCmdJournal         = replayCmd.Flag("journal", "Path to the patch journal written by the server").Required().String()
+	replayCmdPopulation      = replayCmd.Flag("population", "Population file the journal belongs to, for the canvas size and the starting organism. Defaults to the population file next to the journal").String()
+	replayCmdFrames          = replayCmd.Flag("frames", "Prefix of the png frames to render, numbered by iteration like checkpoint snapshots so that genvideo can use them").String()
+	replayCmdEvery           = replayCmd.Flag("every", "Render a frame when at least this many iterations have passed since the previous frame").Int()
+	replayCmdDiffStep        = replayCmd.Flag("diff-step", "Render a frame when the diff has improved by at least this much since the previous frame").Float32()
+	replayCmdIterations      = replayCmd.Flag("iteration", "Render the organism as it was at this iteration. Can be repeated. Every recorded organism is rendered if no frames are chosen").Ints()
+	replayCmdWidth           = replayCmd.Flag("width", "Width of the frames in pixels. Defaults to the width stored in the population file").Short('w').Int()
+	replayCmdHeight          = replayCmd.Flag("height", "Height of the frames in pixels. Defaults to the height stored in the population file").Short('h').Int()
+	replayCmdExportFile      = replayCmd.Flag("export-file", "Population file to export a historical organism to").String()
+	replayCmdExportIteration = replayCmd.Flag("export-iteration", "Export the organism as it was at this iteration. Defaults to the latest organism").Default("-1").Int()
+	replayCmdExportHash      = replayCmd.Flag("export-hash", "Export the organism with this hash").String()
+
+	tileCmd           = app.Command("tile", "Evolves a large target in overlapping tiles and stitches them into one population. Finished tiles are kept, so an interrupted run continues where it stopped.")
+	tileCmdTarget     = tileCmd.Arg("target", "File containing the target image").Required().String()
+	tileCmdFocus      = tileCmd.Flag("focus", "File containing a focus map").String()
+	tileCmdMaxSeconds = tileCmd.Flag("max_seconds", "Maximum number of seconds to run. The tiles finished by then are stitched").Int()
+	tileCmdSize       = tileCmd.Flag("size", "Width and height of the area each tile paints, in pixels. Defaults to TileSize in config.json").Int()
+	tileCmdOverlap    = tileCmd.Flag("overlap", "Pixels of the neighboring tiles each tile is also scored against. Defaults to TileOverlap in config.json").Int()
+	tileCmdRefine     = tileCmd.Flag("refine", "Once all tiles are finished, evolve the stitched organism at full size with mutations focused on the seams until it reaches a plateau. This needs memory for a full size population, which tiling otherwise avoids").Bool()
+	tileCmdOverwrite  = tileCmd.Flag("overwrite", "Replace an existing population file of the target with the stitched one").Bool()
+
+	videoserverCmd       = app.Command("videoserver", "Run a server that splits videos into frames to be painted by browser workers")
+	videoserverCmdPort   = videoserverCmd.Flag("port", "Port to listen on").Default("8081").Int()
+	videoserverCmdFolder = videoserverCmd.Flag("folder", "Folder where video jobs are stored").Default("videojobs").String()
+
 	config *Config
 	// objectPool is global to allow easy access
 	objectPool *ObjectPool
@@ -129,7 +176,10 @@ func loadImage(imageFile string) image.Image {
 
 func createObjectPool() *ObjectPool {
 	p := NewObjectPool()
-	p.AddInstructionFactory(TypePolygon, NewPolygonFactory())
+	for _, name := range InstructionTypeNames() {
+		instructionType, _ := GetInstructionType(name)
+		p.AddInstructionFactory(name, instructionType.NewFactory())
+	}
 	return p
 }
 
@@ -171,8 +221,16 @@ func main() {
 		scale()
 	case renderCmd.FullCommand():
 		render()
+	case exportCmd.FullCommand():
+		export()
 	case downloadCmd.FullCommand():
 		download()
+	case replayCmd.FullCommand():
+		replay()
+	case tileCmd.FullCommand():
+		tile()
+	case videoserverCmd.FullCommand():
+		videoserver()
 	default:
 		log.Fatalf("Unimplemented command: %v", cmd)
 	}
@@ -181,90 +239,327 @@ func main() {
 func compare() {
 	image1 := loadImage(*compareFile1)
 	image2 := loadImage(*compareFile2)
-	ranker := &Ranker{}
+	metricName := *compareMetric
+	if metricName == "" {
+		metricName = config.FitnessMetric
+	}
+	metric, err := NewFitnessMetric(metricName, config)
+	if err != nil {
+		log.Fatalf("Error creating fitness metric: %v", err.Error())
+	}
+	ranker := NewRanker(metric)
 	diff, err := ranker.Distance(image1, image2)
 	if err != nil {
 		log.Fatalf("Error comparing images: %v", err.Error())
 	}
-	fmt.Printf("Diff: %v", diff)
+	fmt.Printf("Diff: %v (similarity=%v, metric=%v)", diff, ranker.FormatProgress(diff), metric.Name())
 }
 
 func download() {
-	workerClient := NewWorkerClient(*downloadEndpoint)
+	workerClient := createWorkerClient(*downloadEndpoint)
+	targetImageData, err := workerClient.GetTargetImageData()
+	if err != nil {
+		panic(err)
+	}
+	targetConfig, err := png.DecodeConfig(bytes.NewReader(targetImageData))
+	if err != nil {
+		panic(err)
+	}
 	organism, err := workerClient.GetTopOrganism()
 	if err != nil {
 		panic(err)
 	}
-	file, err := os.Create(*downloadOutfile)
+	populationFile := NewPopulationFile(targetConfig.Width, targetConfig.Height, config)
+	populationFile.Organisms = append(populationFile.Organisms, organism.Save())
+	err = populationFile.Save(*downloadOutfile)
 	if err != nil {
 		panic(err)
 	}
-	defer file.Close()
-	line := organism.SaveV2()
-	file.Write(line)
-	file.WriteString("\n")
 }
 
-func scale() {
-	file, err := os.Open(*scaleCmdFile)
+func videoserver() {
+	server := NewVideoServer(*videoserverCmdFolder)
+	err := server.Start(fmt.Sprintf("0.0.0.0:%v", *videoserverCmdPort))
 	if err != nil {
-		panic(err.Error())
+		log.Fatalf("Error running video server: '%v'", err.Error())
 	}
-	defer file.Close()
-	outfile, err := os.Create(*scaleCmdOutputFile)
+}
+
+func scale() {
+	populationFile, err := LoadPopulationFile(*scaleCmdFile)
 	if err != nil {
 		panic(err.Error())
 	}
-	defer outfile.Close()
-	reader := bufio.NewScanner(file)
-	// 10 MB buffer for organisms, they might be really big. Adjust as needed.
-	buf := make([]byte, 1024*1024*10)
-	reader.Buffer(buf, len(buf))
-	reader.Scan()
-	iterationLine := reader.Text()
-
-	for reader.Scan() {
-		line := reader.Bytes()
-		if len(line) == 0 {
-			continue
-		}
+	header := &populationFile.Header
+	if header.HasDimensions() {
+		header.Width = int(math.Round(float64(float32(header.Width) * *scaleCmdFactor)))
+		header.Height = int(math.Round(float64(float32(header.Height) * *scaleCmdFactor)))
+	}
+	for i, line := range populationFile.Organisms {
 		organism := &Organism{}
 		organism.Load(line)
-		for i, instruction := range organism.Instructions {
-			instruction = instruction.Scale(*scaleCmdFactor)
-			organism.Instructions[i] = instruction
+		for j, instruction := range organism.Instructions {
+			organism.Instructions[j] = instruction.Scale(*scaleCmdFactor)
 		}
-		line = organism.Save()
-		outfile.WriteString(iterationLine)
-		// TODO: verify if this newline is needed or not...
-		outfile.WriteString("\n")
-		outfile.Write(line)
-		outfile.WriteString("\n")
+		populationFile.Organisms[i] = organism.Save()
+	}
+	err = populationFile.Save(*scaleCmdOutputFile)
+	if err != nil {
+		panic(err.Error())
 	}
 }
 
 func render() {
-	file, err := os.Open(*renderCmdFile)
+	populationFile, err := LoadPopulationFile(*renderCmdFile)
 	if err != nil {
-		panic(err.Error())
+		log.Fatalf("Error loading population file: '%v'", err.Error())
 	}
-	defer file.Close()
-	reader := bufio.NewScanner(file)
-	// 10 MB buffer for organisms, they might be really big. Adjust as needed.
-	buf := make([]byte, 1024*1024*10)
-	reader.Buffer(buf, len(buf))
-	// skip first line
-	reader.Scan()
-	if reader.Scan() {
-		line := reader.Bytes()
-		organism := &Organism{}
-		organism.Load(line)
-		renderer := NewRenderer(*renderCmdWidth, *renderCmdHeight)
-		renderer.Render(organism.Instructions)
-		renderer.SaveToFile(*renderCmdOutputFile)
-	} else {
+	organism := populationFile.TopOrganism()
+	if organism == nil {
 		log.Println("No organisms found in file")
+		return
+	}
+	width, height := outputSize(&populationFile.Header, *renderCmdWidth, *renderCmdHeight)
+	if populationFile.Header.HasDimensions() && width != populationFile.Header.Width {
+		factor := float32(width) / float32(populationFile.Header.Width)
+		for i, instruction := range organism.Instructions {
+			organism.Instructions[i] = instruction.Scale(factor)
+		}
+	}
+	renderer := NewRenderer(width, height)
+	renderer.Render(organism)
+	renderer.SaveToFile(*renderCmdOutputFile)
+}
+
+func export() {
+	populationFile, err := LoadPopulationFile(*exportCmdFile)
+	if err != nil {
+		log.Fatalf("Error loading population file: '%v'", err.Error())
+	}
+	organism := populationFile.TopOrganism()
+	if organism == nil {
+		log.Println("No organisms found in file")
+		return
+	}
+	width, height := outputSize(&populationFile.Header, *exportCmdWidth, *exportCmdHeight)
+	outfile, err := os.Create(*exportCmdOutputFile)
+	if err != nil {
+		log.Fatalf("Error creating output file: '%v'", err.Error())
+	}
+	defer outfile.Close()
+	switch *exportCmdFormat {
+	case "svg":
+		exporter := NewSVGExporter(width, height)
+		if populationFile.Header.HasDimensions() {
+			// Keep the original coordinate space and let the viewer scale it
+			exporter = NewSVGExporter(populationFile.Header.Width, populationFile.Header.Height)
+			exporter.SetOutputSize(width, height)
+		}
+		err = exporter.Export(organism, outfile)
+	}
+	if err != nil {
+		log.Fatalf("Error exporting organism: '%v'", err.Error())
+	}
+	log.Printf("Exported %v instructions to '%v'", len(organism.Instructions), *exportCmdOutputFile)
+}
+
+// replay reconstructs the organisms recorded in a patch journal, oldest
+// first, and renders the chosen ones. An organism is "at" an iteration if it
+// was the top organism then, so it may have been recorded earlier.
+func replay() {
+	if _, err := os.Stat(*replayCmdJournal); err != nil {
+		log.Fatalf("Error opening patch journal: %v", err.Error())
+	}
+	journal, err := OpenPatchJournal(*replayCmdJournal)
+	if err != nil {
+		log.Fatalf("Error opening patch journal: %v", err.Error())
+	}
+	defer journal.Close()
+	populationFilename := *replayCmdPopulation
+	if populationFilename == "" {
+		populationFilename = strings.TrimSuffix(*replayCmdJournal, ".journal") + ".population.txt"
+	}
+	populationFile, err := LoadPopulationFile(populationFilename)
+	if err != nil {
+		log.Fatalf("Error loading population file: '%v'", err.Error())
+	}
+	header := &populationFile.Header
+
+	target := journal.Latest()
+	if target == "" {
+		log.Println("No entries found in journal")
+		return
+	}
+
+	width, height := outputSize(header, *replayCmdWidth, *replayCmdHeight)
+	factor := float32(1)
+	objectPool.SetRendererBounds(width, height)
+	if header.HasDimensions() {
+		factor = float32(width) / float32(header.Width)
+		objectPool.SetRendererBounds(header.Width, header.Height)
+	}
+	// Start from the beginning of the lineage if it was recorded, otherwise
+	// from the organism it was recorded from
+	var start *Organism
+	if origin := journal.Origin(target); origin != "<none>" {
+		start = FindCheckpointOrganism(populationFilename, origin)
+		if start == nil {
+			log.Fatalf("The journal starts from organism %v, which isn't in %v or its older checkpoints", origin, populationFilename)
+		}
+	}
+	renderer := NewRenderer(width, height)
+	frames := 0
+	renderFrame := func(organism *Organism, iteration int) {
+		scaled := &Organism{Background: organism.Background, Instructions: organism.Instructions}
+		if factor != 1 {
+			scaled.Instructions = make([]Instruction, len(organism.Instructions))
+			for i, instruction := range organism.Instructions {
+				scaled.Instructions[i] = instruction.Scale(factor)
+			}
+		}
+		renderer.Render(scaled)
+		if factor != 1 {
+			for _, instruction := range scaled.Instructions {
+				objectPool.ReturnInstruction(instruction)
+			}
+		}
+		err := renderer.SaveToFile(fmt.Sprintf("%v.%07d.png", *replayCmdFrames, iteration))
+		if err != nil {
+			log.Fatalf("Error saving frame: %v", err.Error())
+		}
+		frames++
+	}
+	exportOrganism := func(organism *Organism, entry *JournalEntry) {
+		exported := NewPopulationFile(header.Width, header.Height, config)
+		exported.Header.Iteration = entry.Iteration
+		exported.Header.Target = header.Target
+		exported.Header.Diff = entry.Diff
+		exported.Organisms = append(exported.Organisms, organism.Save())
+		err := exported.Save(*replayCmdExportFile)
+		if err != nil {
+			log.Fatalf("Error exporting organism: %v", err.Error())
+		}
+		log.Printf("Exported %v from iteration %v to '%v'", organism.Hash(), entry.Iteration, *replayCmdExportFile)
+	}
+
+	iterations := append([]int{}, *replayCmdIterations...)
+	sort.Ints(iterations)
+	renderAll := *replayCmdEvery <= 0 && *replayCmdDiffStep <= 0 && len(iterations) == 0
+	if *replayCmdFrames == "" {
+		renderAll = false
+		iterations = nil
+	}
+	exportIteration := -1
+	if *replayCmdExportFile != "" && *replayCmdExportHash == "" {
+		exportIteration = *replayCmdExportIteration
+	}
+	exported := false
+
+	// The previous organism is kept for the iterations between its entry and
+	// the next one. Iterations before the first entry show the organism the
+	// journal starts from, which has no entry.
+	var previous *Organism
+	var previousEntry *JournalEntry
+	if start != nil {
+		previous = start.Clone()
+	}
+	var lastFrame *JournalEntry
+	replayed := 0
+	err = journal.Replay(target, start, func(entry *JournalEntry, organism *Organism) bool {
+		replayed++
+		for len(iterations) > 0 && iterations[0] < entry.Iteration {
+			if previous != nil {
+				renderFrame(previous, iterations[0])
+			} else {
+				log.Printf("Skipping iteration %v, which is before the first journal entry", iterations[0])
+			}
+			iterations = iterations[1:]
+		}
+		if exportIteration >= 0 && exportIteration < entry.Iteration {
+			if previous == nil {
+				log.Fatalf("Iteration %v is before the first journal entry, which starts from an empty organism", exportIteration)
+			}
+			if previousEntry == nil {
+				previousEntry = &JournalEntry{Iteration: exportIteration, Diff: previous.Diff}
+			}
+			exportOrganism(previous, previousEntry)
+			exported = true
+			exportIteration = -1
+		}
+		if *replayCmdExportFile != "" && organism.Hash() == *replayCmdExportHash {
+			exportOrganism(organism, entry)
+			exported = true
+		}
+		if *replayCmdFrames != "" {
+			due := renderAll || lastFrame == nil
+			if *replayCmdEvery > 0 && lastFrame != nil && entry.Iteration-lastFrame.Iteration >= *replayCmdEvery {
+				due = true
+			}
+			if *replayCmdDiffStep > 0 && lastFrame != nil && lastFrame.Diff-entry.Diff >= *replayCmdDiffStep {
+				due = true
+			}
+			if due && (renderAll || *replayCmdEvery > 0 || *replayCmdDiffStep > 0) {
+				renderFrame(organism, entry.Iteration)
+				lastFrame = entry
+			}
+		}
+		if previous != nil {
+			objectPool.ReturnOrganism(previous)
+		}
+		previous = organism.Clone()
+		previousEntry = entry
+		return true
+	})
+	if err != nil {
+		log.Fatalf("Error replaying patch journal: %v", err.Error())
+	}
+	if previous != nil {
+		// The latest organism is the top organism for all later iterations
+		for _, iteration := range iterations {
+			renderFrame(previous, iteration)
+		}
+		if lastFrame != previousEntry && (*replayCmdEvery > 0 || *replayCmdDiffStep > 0) {
+			renderFrame(previous, previousEntry.Iteration)
+		}
+		if exportIteration >= 0 || (*replayCmdExportFile != "" && *replayCmdExportHash == "" && !exported) {
+			exportOrganism(previous, previousEntry)
+			exported = true
+		}
+		objectPool.ReturnOrganism(previous)
+	}
+	if *replayCmdExportFile != "" && !exported {
+		log.Fatalf("No organism to export was found in the journal")
+	}
+	log.Printf("Replayed %v journal entries, rendered %v frames", replayed, frames)
+}
+
+// outputSize determines the size of the image produced from a population
+// file. The size recorded in the header is used unless it is overridden. If
+// only one dimension is overridden, the other keeps the aspect ratio.
+func outputSize(header *PopulationHeader, width int, height int) (int, int) {
+	if !header.HasDimensions() {
+		if width <= 0 || height <= 0 {
+			log.Fatalf("Population file doesn't record its dimensions, --width and --height are required")
+		}
+		return width, height
 	}
+	if width <= 0 && height <= 0 {
+		return header.Width, header.Height
+	}
+	if width <= 0 {
+		width = int(math.Round(float64(header.Width) * float64(height) / float64(header.Height)))
+	}
+	if height <= 0 {
+		height = int(math.Round(float64(header.Height) * float64(width) / float64(header.Width)))
+	}
+	// Instructions are scaled by the same factor horizontally and vertically,
+	// circles can't be stretched. Allow for rounding of either dimension.
+	expectedHeight := float64(header.Height) * float64(width) / float64(header.Width)
+	tolerance := 1 + float64(header.Height)/float64(header.Width)
+	if math.Abs(float64(height)-expectedHeight) > tolerance {
+		log.Fatalf("%vx%v doesn't match the %vx%v aspect ratio of the population file, pass only --width or --height", width, height, header.Width, header.Height)
+	}
+	return width, height
 }
 
 func server() {
@@ -275,103 +570,357 @@ func server() {
 	if *focusFile != "" {
 		focusImage = loadImage(*focusFile)
 	}
-	targetFilename := *targetFile
-	if strings.Contains(targetFilename, "\\") {
-		parts := strings.Split(targetFilename, "\\")
-		targetFilename = parts[len(parts)-1]
-	} else if strings.Contains(targetFilename, "/") {
-		parts := strings.Split(targetFilename, "/")
-		targetFilename = parts[len(parts)-1]
-	}
+	targetFilename := targetBaseName(*targetFile)
 	log.Printf("Target file: %v", targetFilename)
 	incubatorFilename := targetFilename + ".population.txt"
-	renderer := NewRenderer(target.Bounds().Size().X, target.Bounds().Size().Y)
-	mutator := createMutator(target, focusImage)
+	mutator := createMutator(config, target, focusImage)
+
+	// The portal stops as soon as a shutdown is requested, the incubator keeps
+	// running until the final checkpoint has been saved
+	ctx, shutdown := shutdownContext()
+	defer shutdown()
+	runCtx, stop := context.WithCancel(context.Background())
+	defer stop()
 
-	ranker := NewRanker()
+	var seed *Organism
+	if levels := intOption(*serverPyramid, config.PyramidLevels); levels > 1 {
+		if CheckpointExists(incubatorFilename) {
+			log.Println("Skipping the pyramid, the previous population is already at full size")
+		} else {
+			seed = evolvePyramid(ctx, BuildPyramid(target, focusImage, levels, config.PyramidScale))
+		}
+	}
+
+	ranker := createRanker()
 	incubator := NewIncubator(config, target, mutator, ranker)
-	incubator.Start()
+	incubator.TargetName = targetFilename
+	incubator.Start(runCtx)
 	bestDiff := float32(1000.0)
+	bestRawDiff := bestDiff
 	instructionCount := 0
-	_, err := os.Stat(incubatorFilename)
-	if err == nil {
+	loadedHash := ""
+	if CheckpointExists(incubatorFilename) {
 		log.Println("Loading previous population")
 		incubator.Load(incubatorFilename)
 		topOrganism := incubator.GetTopOrganism()
 		bestDiff = topOrganism.Diff
+		bestRawDiff = topOrganism.RawDiff
 		instructionCount = len(topOrganism.Instructions)
 		log.Printf("Hash=%v, Initial diff: %v", topOrganism.Hash(), bestDiff)
+		loadedHash = topOrganism.Hash()
+		objectPool.ReturnOrganism(topOrganism)
+	} else if seed != nil {
+		incubator.SetTopOrganism(seed)
+		objectPool.ReturnOrganism(seed)
+		topOrganism := incubator.GetTopOrganism()
+		bestDiff = topOrganism.Diff
+		bestRawDiff = topOrganism.RawDiff
+		instructionCount = len(topOrganism.Instructions)
+		log.Printf("Diff at full size: %v", bestDiff)
 		objectPool.ReturnOrganism(topOrganism)
 	}
 
+	journal, err := OpenPatchJournal(targetFilename + ".journal")
+	if err != nil {
+		log.Fatalf("Error opening patch journal: %v", err.Error())
+	}
+	log.Printf("Patch journal has %v entries", journal.Len())
+	if loadedHash != "" {
+		err = journal.Compact(loadedHash)
+		if err != nil {
+			log.Printf("Error compacting patch journal: %v", err.Error())
+		}
+	}
+
 	// Launch external server handler
-	serverPortal := NewServerPortal(incubator, focusImage)
-	serverPortal.Start()
+	serverPortal := NewServerPortal(
+		incubator,
+		focusImage,
+		journal,
+		net.JoinHostPort(stringOption(*serverListen, config.ListenAddress), strconv.Itoa(intOption(*serverPort, config.ListenPort))),
+		stringOption(*serverTLSCert, config.TLSCertFile),
+		stringOption(*serverTLSKey, config.TLSKeyFile),
+		stringOption(*authSecret, config.AuthSecret),
+		config.MaxRequestSize,
+	)
+	serverPortal.Start(ctx)
 
 	lastSave := time.Now()
-	for {
+	for ctx.Err() == nil {
 		if incubator.Iteration%gcFrequency == 0 {
 			log.Println("Running garbage collection")
 			runtime.GC()
 			log.Println("garbage collection completed")
 		}
 		if *serverMaxSeconds != 0 && time.Since(start) >= time.Second*time.Duration(*serverMaxSeconds) {
-			return
+			log.Printf("Stopping after %v seconds", *serverMaxSeconds)
+			shutdown()
+			break
 		}
 		// if (memprof != nil || prof != nil) && time.Since(start) >= profileDuration {
 		// 	return
 		// }
 		incubator.Iterate()
 		serverPortal.Update()
-		// stats := incubator.GetIncubatorStats()
-		displayProgress(bestDiff, instructionCount)
+		displayProgress(ranker, incubator.GetIncubatorStats(), bestDiff, bestRawDiff, instructionCount)
 		topOrganism := incubator.GetTopOrganism()
 		if topOrganism.Diff < bestDiff {
 			bestDiff = topOrganism.Diff
+			bestRawDiff = topOrganism.RawDiff
 			instructionCount = len(topOrganism.Instructions)
 			if time.Since(lastSave) > time.Minute {
-				incubator.Save(incubatorFilename)
-				// incubator.Load(incubatorFilename)
-
-				renderer = objectPool.BorrowRenderer() //NewRenderer(target.Bounds().Size().X, target.Bounds().Size().Y)
-				renderer.Render(topOrganism.Instructions)
-				renderer.SaveToFile(fmt.Sprintf("%v.%07d.png", targetFilename, incubator.Iteration))
+				saveCheckpoint(incubator, incubatorFilename, targetFilename)
 				lastSave = time.Now()
-				log.Printf("%v updated", incubatorFilename)
-				objectPool.ReturnRenderer(renderer)
 			}
 		}
 		objectPool.ReturnOrganism(topOrganism)
 	}
+
+	<-serverPortal.Done()
+	log.Println("Saving final checkpoint")
+	saveCheckpoint(incubator, incubatorFilename, targetFilename)
 }
 
-func createMutator(target image.Image, focusImage image.Image) *Mutator {
-	lineMutator := NewLineMutator(config, float32(target.Bounds().Size().X), float32(target.Bounds().Size().Y))
-	circleMutator := NewCircleMutator(config, float32(target.Bounds().Size().X), float32(target.Bounds().Size().Y))
-	polygonMutator := NewPolygonMutator(config, float32(target.Bounds().Size().X), float32(target.Bounds().Size().Y))
-	instructionMutators := []InstructionMutator{}
-	for _, instructionType := range config.InstructionTypes {
-		if instructionType == TypeCircle {
-			instructionMutators = append(instructionMutators, circleMutator)
+func tile() {
+	target := loadImage(*tileCmdTarget)
+	var focusImage image.Image
+	if *tileCmdFocus != "" {
+		focusImage = loadImage(*tileCmdFocus)
+	}
+	targetFilename := targetBaseName(*tileCmdTarget)
+	log.Printf("Target file: %v", targetFilename)
+	incubatorFilename := targetFilename + ".population.txt"
+	if !*tileCmdOverwrite && CheckpointExists(incubatorFilename) {
+		log.Fatalf("%v already exists, pass --overwrite to replace it with the stitched population", incubatorFilename)
+	}
+
+	ctx, shutdown := shutdownContext()
+	defer shutdown()
+	if *tileCmdMaxSeconds != 0 {
+		ctx, shutdown = context.WithTimeout(ctx, time.Second*time.Duration(*tileCmdMaxSeconds))
+		defer shutdown()
+	}
+
+	tiler := NewTiler(
+		config,
+		target,
+		focusImage,
+		targetFilename,
+		intOption(*tileCmdSize, config.TileSize),
+		intOption(*tileCmdOverlap, config.TileOverlap),
+	)
+	tiler.Run(ctx)
+	organism, unfinished := tiler.Stitch()
+	if unfinished > 0 {
+		log.Printf("%v of %v tiles are unfinished, run again with --overwrite to continue", unfinished, len(tiler.Tiles()))
+	} else if *tileCmdRefine && len(tiler.Tiles()) > 1 && ctx.Err() == nil {
+		refined := tiler.Refine(ctx, organism)
+		objectPool.ReturnOrganism(organism)
+		organism = refined
+	}
+	defer objectPool.ReturnOrganism(organism)
+
+	// Score the stitched organism against the whole target, so that the seams
+	// count as well
+	size := target.Bounds().Size()
+	renderer := objectPool.BorrowRenderer()
+	renderer.Render(organism)
+	ranker := createRanker()
+	rawDiff, err := ranker.Distance(target, renderer.GetImage())
+	if err != nil {
+		log.Fatalf("Error scoring stitched organism: %v", err.Error())
+	}
+	renderer.SaveToFile(targetFilename + ".tiled.png")
+	objectPool.ReturnRenderer(renderer)
+	log.Printf("Stitched %v instructions, diff: %v", len(organism.Instructions), rawDiff)
+
+	populationFile := NewPopulationFile(size.X, size.Y, config)
+	populationFile.Header.Target = targetFilename
+	populationFile.Header.RawDiff = rawDiff
+	populationFile.Header.Diff = ranker.Fitness(rawDiff, len(organism.Instructions))
+	populationFile.Organisms = append(populationFile.Organisms, organism.Save())
+	err = SaveCheckpoint(incubatorFilename, populationFile, config.CheckpointHistory)
+	if err != nil {
+		log.Fatalf("Error saving stitched population: %v", err.Error())
+	}
+	log.Printf("%v updated", incubatorFilename)
+}
+
+// evolvePyramid evolves the coarse levels of a pyramid in turn, each until
+// it reaches a plateau, and seeds each level with the result of the previous
+// one. It returns the result scaled to the full size target, or nil if it was
+// cancelled before the first level. If it is cancelled during a level, the
+// result so far is returned.
+func evolvePyramid(ctx context.Context, pyramid []PyramidLevel) *Organism {
+	var background color.RGBA
+	var instructions []Instruction // Result of the previous level, scaled to the current one
+	iterations := 0
+	for i, level := range pyramid[:len(pyramid)-1] {
+		if ctx.Err() != nil {
+			break
+		}
+		size := level.Target.Bounds().Size()
+		log.Printf("Evolving pyramid level %v of %v at %vx%v", i+1, len(pyramid), size.X, size.Y)
+		objectPool.SetRendererBounds(size.X, size.Y)
+		levelConfig := ScaleConfig(config, level.Factor)
+		ranker := createRanker()
+		incubator := NewIncubator(levelConfig, level.Target, createMutator(levelConfig, level.Target, level.Focus), ranker)
+		levelCtx, stop := context.WithCancel(context.Background())
+		incubator.Start(levelCtx)
+		if instructions != nil {
+			seed := objectPool.BorrowOrganism()
+			seed.Background = background
+			// The seed takes over the scaled instructions and returns them
+			// to the pool along with itself
+			seed.Instructions = append(seed.Instructions, instructions...)
+			incubator.SetTopOrganism(seed)
+			objectPool.ReturnOrganism(seed)
 		}
-		if instructionType == TypeLine {
-			instructionMutators = append(instructionMutators, lineMutator)
+
+		plateau := NewPlateauDetector(config.PlateauWindow, config.PlateauThreshold)
+		for ctx.Err() == nil {
+			incubator.Iterate()
+			topOrganism := incubator.GetTopOrganism()
+			displayProgress(ranker, incubator.GetIncubatorStats(), topOrganism.Diff, topOrganism.RawDiff, len(topOrganism.Instructions))
+			diff := topOrganism.Diff
+			objectPool.ReturnOrganism(topOrganism)
+			if plateau.Record(diff) {
+				log.Printf("Pyramid level %v reached a plateau at diff %v", i+1, diff)
+				break
+			}
 		}
-		if instructionType == TypePolygon {
-			instructionMutators = append(instructionMutators, polygonMutator)
+
+		// Scale the result to the next level, or straight to full size when
+		// stopping early. The organisms of this level have to be back in the
+		// pool before its size changes.
+		factor := pyramid[i+1].Factor / level.Factor
+		if ctx.Err() != nil {
+			factor = 1 / level.Factor
 		}
+		topOrganism := incubator.GetTopOrganism()
+		background = topOrganism.Background
+		instructions = make([]Instruction, 0, len(topOrganism.Instructions))
+		for _, instruction := range topOrganism.Instructions {
+			instructions = append(instructions, instruction.Scale(factor))
+		}
+		objectPool.ReturnOrganism(topOrganism)
+		stop()
+		incubator.Dispose()
+		iterations += incubator.Iteration
+	}
+
+	size := pyramid[len(pyramid)-1].Target.Bounds().Size()
+	objectPool.SetRendererBounds(size.X, size.Y)
+	if instructions == nil {
+		return nil
+	}
+	log.Printf("Pyramid finished after %v iterations, continuing at full size", iterations)
+	organism := objectPool.BorrowOrganism()
+	organism.Background = background
+	organism.Instructions = append(organism.Instructions, instructions...)
+	return organism
+}
+
+// targetBaseName returns the filename of the target without its folder, which
+// names the files saved next to it
+func targetBaseName(targetFile string) string {
+	if strings.Contains(targetFile, "\\") {
+		parts := strings.Split(targetFile, "\\")
+		return parts[len(parts)-1]
+	} else if strings.Contains(targetFile, "/") {
+		parts := strings.Split(targetFile, "/")
+		return parts[len(parts)-1]
 	}
-	mutator := NewMutator(instructionMutators, focusImage)
+	return targetFile
+}
+
+// saveCheckpoint saves the population and a snapshot of the top organism
+func saveCheckpoint(incubator *Incubator, incubatorFilename string, targetFilename string) {
+	incubator.Save(incubatorFilename)
+	topOrganism := incubator.GetTopOrganism()
+	renderer := objectPool.BorrowRenderer()
+	renderer.Render(topOrganism)
+	renderer.SaveToFile(fmt.Sprintf("%v.%07d.png", targetFilename, incubator.Iteration))
+	objectPool.ReturnRenderer(renderer)
+	objectPool.ReturnOrganism(topOrganism)
+	log.Printf("%v updated", incubatorFilename)
+}
+
+func createMutator(mutatorConfig *Config, target image.Image, focusImage image.Image) *Mutator {
+	instructionMutators := []InstructionMutator{}
+	for _, name := range config.InstructionTypes {
+		instructionType, err := GetInstructionType(name)
+		if err != nil {
+			log.Fatalf("Error in InstructionTypes: %v (available types: %v)", err.Error(), strings.Join(InstructionTypeNames(), ", "))
+		}
+		instructionMutators = append(
+			instructionMutators,
+			instructionType.NewMutator(mutatorConfig, float32(target.Bounds().Size().X), float32(target.Bounds().Size().Y)))
+	}
+	mutator := NewMutator(mutatorConfig, instructionMutators, focusImage)
 	return mutator
 }
 
-func displayProgress(bestDiff float32, instructionCount int) {
-	log.Printf("Similarity: %v (diff=%v, instructions=%v)", FormatProgress(bestDiff), bestDiff, instructionCount)
+// createRanker creates a ranker using the fitness metric from the config
+func createRanker() *Ranker {
+	metric, err := NewFitnessMetric(config.FitnessMetric, config)
+	if err != nil {
+		log.Fatalf("Error creating fitness metric: '%v'", err.Error())
+	}
+	log.Printf("Fitness metric: %v", metric.Name())
+	ranker := NewRanker(metric)
+	ranker.SetComplexityPenalty(config.ComplexityThreshold, config.ComplexityPenalty)
+	return ranker
+}
+
+// createWorkerClient creates a client for the server at endpoint, using the
+// auth secret and certificate authority from the flags or config
+func createWorkerClient(endpoint string) *WorkerClient {
+	client, err := NewWorkerClient(endpoint, stringOption(*authSecret, config.AuthSecret), stringOption(*tlsCAFile, config.TLSCAFile))
+	if err != nil {
+		log.Fatalf("Error creating client: '%v'", err.Error())
+	}
+	return client
+}
+
+// stringOption returns the flag value if it was set, otherwise the config value
+func stringOption(flag string, configValue string) string {
+	if flag != "" {
+		return flag
+	}
+	return configValue
+}
+
+// intOption returns the flag value if it was set, otherwise the config value
+func intOption(flag int, configValue int) int {
+	if flag != 0 {
+		return flag
+	}
+	return configValue
+}
+
+// displayProgress logs the similarity of the best organism, which ignores the
+// complexity penalty, along with its penalized diff
+func displayProgress(ranker *Ranker, stats *IncubatorStats, bestDiff float32, bestRawDiff float32, instructionCount int) {
+	log.Printf(
+		"Similarity: %v (diff=%v, raw=%v, instructions=%v, selection=%v, mutations=%v)",
+		ranker.FormatProgress(bestRawDiff), bestDiff, bestRawDiff, instructionCount, stats.Selection, formatMutationShares(stats.MutationShares))
+}
+
+// formatMutationShares lists mutation operations with their percentage of
+// mutations, in a stable order
+func formatMutationShares(shares map[string]float32) string {
+	parts := []string{}
+	for operation := MutationNone + 1; operation < mutationCount; operation++ {
+		name := mutationNames[operation]
+		parts = append(parts, fmt.Sprintf("%v:%.0f%%", name, shares[name]*100))
+	}
+	return strings.Join(parts, " ")
 }
 
 func worker() {
 	// start := time.Now()
-	client := NewWorkerClient(*endpoint)
+	client := createWorkerClient(*endpoint)
 	targetImageData, err := client.GetTargetImageData()
 	if err != nil {
 		log.Fatalf("Error getting target image: '%v'", err.Error())
@@ -393,10 +942,18 @@ func worker() {
 			log.Println("Focus image is active")
 		}
 	}
-	mutator := createMutator(target, focusImage)
-	ranker := NewRanker()
+	mutator := createMutator(config, target, focusImage)
+	ranker := createRanker()
+
+	// The portal stops as soon as a shutdown is requested, the incubator keeps
+	// running until pending organisms have been exported
+	ctx, shutdown := shutdownContext()
+	defer shutdown()
+	runCtx, stop := context.WithCancel(context.Background())
+	defer stop()
+
 	incubator := NewIncubator(config, target, mutator, ranker)
-	incubator.Start()
+	incubator.Start(runCtx)
 
 	// Load seed organisms from the server
 	log.Println("Getting seed organism from server...")
@@ -411,20 +968,27 @@ func worker() {
 	// Start up worker portal
 	portal := NewWorkerPortal(client)
 	portal.Init(organism)
-	portal.Start()
+	cpus := config.WorkerCount
+	if cpus <= 0 {
+		cpus = runtime.NumCPU()
+	}
+	portal.Register(cpus)
+	portal.Start(ctx)
 
 	bestDiff := float32(1000.0)
+	bestRawDiff := bestDiff
 	instructionCount := 0
 
 	if err == nil {
 		topOrganism := incubator.GetTopOrganism()
 		bestDiff = topOrganism.Diff
+		bestRawDiff = topOrganism.RawDiff
 		instructionCount = len(topOrganism.Instructions)
-		log.Printf("Initial similarity: %.15f%%", (1.0-(bestDiff/maxImageDiff))*100)
+		log.Printf("Initial similarity: %v", ranker.FormatProgress(bestDiff))
 		objectPool.ReturnOrganism(topOrganism)
 	}
 
-	for {
+	for ctx.Err() == nil {
 		if incubator.Iteration%gcFrequency == 0 {
 			log.Println("Running garbage collection")
 			runtime.GC()
@@ -439,6 +1003,7 @@ func worker() {
 		topOrganism := incubator.GetTopOrganism()
 		if topOrganism.Diff < bestDiff && topOrganism.Diff != -1 {
 			bestDiff = topOrganism.Diff
+			bestRawDiff = topOrganism.RawDiff
 			instructionCount = len(topOrganism.Instructions)
 			portal.Export(topOrganism)
 		}
@@ -449,73 +1014,66 @@ func worker() {
 			incubator.Iterate()
 			topOrganism := incubator.GetTopOrganism()
 			bestDiff = topOrganism.Diff
+			bestRawDiff = topOrganism.RawDiff
 			instructionCount = len(topOrganism.Instructions)
 			objectPool.ReturnOrganism(topOrganism)
 			objectPool.ReturnOrganism(imported)
 		}
 
-		displayProgress(bestDiff, instructionCount)
+		portal.ReportProgress(incubator.Iteration, bestDiff)
+		displayProgress(ranker, incubator.GetIncubatorStats(), bestDiff, bestRawDiff, instructionCount)
 	}
+
+	<-portal.Done()
+	log.Println("Exporting pending organisms")
+	portal.Flush()
 }
 
 // Generates an mp4 video file from a sequence of rendered organisms, showing
 // the path of evolution to the final image.
 func genvideo() {
-	// Create a local tmp dir
-	os.RemoveAll("tmp")
-	err := os.MkdirAll("tmp", 0755)
-	if err != nil {
-		log.Fatalf("Error getting temporary folder: '%v'", err.Error())
-	}
-	// Get list of existing files
-	files, err := ioutil.ReadDir(*genvideoCmdSourceDir)
+	sequence, err := ListFrameSequence(*genvideoCmdSourceDir, *genvideoCmdPrefix)
 	if err != nil {
 		log.Fatalf("Error getting list of files for '%v': '%v'", *genvideoCmdSourceDir, err.Error())
 	}
-	srcNumFrames := len(files)
+	if sequence.Len() == 0 {
+		log.Fatalf("No numbered png files starting with '%v' found in '%v'", *genvideoCmdPrefix, *genvideoCmdSourceDir)
+	}
+	crossfade := *genvideoCmdCrossfade
+	if crossfade < 0 {
+		crossfade = 0
+	}
 	destNumFrames := framesPerSecond * *genvideoCmdLength
-	skip := srcNumFrames / destNumFrames
-	if skip < 1 {
-		skip = 1
-	}
-	count := 0
-	outputNum := 0
-	for _, fileinfo := range files {
-		if strings.HasPrefix(fileinfo.Name(), *genvideoCmdPrefix) {
-			if count%skip == 0 {
-				sourceFilename := fmt.Sprintf("%v/%v", *genvideoCmdSourceDir, fileinfo.Name())
-				destinationFilename := fmt.Sprintf("%v/%v", "tmp", fmt.Sprintf("%05v.png", outputNum))
-				log.Printf("Copying '%v' to '%v'", sourceFilename, destinationFilename)
-				// read data
-				data, err := ioutil.ReadFile(sourceFilename)
-				if err != nil {
-					log.Fatalf("Read error: '%v'", err.Error())
-				}
-				err = ioutil.WriteFile(destinationFilename, data, 0644)
+	sequence.Sample((destNumFrames-1)/(crossfade+1) + 1)
+
+	encoder, err := NewVideoEncoder(*genvideoCmdOutfile, *genvideoCmdEncoder, framesPerSecond, *genvideoCmdQuality)
+	if err != nil {
+		log.Fatalf("Error creating video encoder: '%v'", err.Error())
+	}
+	frames := 0
+	var previous *image.RGBA
+	for _, filename := range sequence.Filenames {
+		log.Printf("Encoding '%v'", filename)
+		frame := opaqueFrame(loadImage(filename))
+		if previous != nil && previous.Bounds() == frame.Bounds() {
+			for i := 1; i <= crossfade; i++ {
+				err = encoder.AddFrame(crossfadeFrames(previous, frame, float32(i)/float32(crossfade+1)))
 				if err != nil {
-					log.Fatalf("Write error: '%v'", err.Error())
+					log.Fatalf("Error encoding frame: '%v'", err.Error())
 				}
-				outputNum++
+				frames++
 			}
-			count++
-		}
-	}
-	// ffmpeg -framerate 10 -pattern_type glob -i "(prefix)*.png" video.mp4
-	ffmpeg := exec.Command(
-		"ffmpeg",
-		"-y",
-		"-framerate",
-		fmt.Sprint(framesPerSecond),
-		"-i",
-		// -i C:\myimages\img%03d.png
-		fmt.Sprintf("%v/%%05d.png", "tmp"),
-		*genvideoCmdOutfile,
-	)
-	log.Printf("Running video encoder command...")
-	ffmpeg.Stderr = os.Stderr
-	ffmpeg.Stdout = os.Stdout
-	err = ffmpeg.Run()
+		}
+		err = encoder.AddFrame(frame)
+		if err != nil {
+			log.Fatalf("Error encoding '%v': '%v'", filename, err.Error())
+		}
+		frames++
+		previous = frame
+	}
+	err = encoder.Close()
 	if err != nil {
 		log.Fatalf("Error running video encoder: '%v'", err.Error())
 	}
+	log.Printf("Wrote %v frames to '%v'", frames, *genvideoCmdOutfile)
 }
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"io/ioutil"
+	"log"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// frameNumberPattern matches the number at the end of a frame filename, such
+// as the iteration in "target.png.0012345.png"
+var frameNumberPattern = regexp.MustCompile(`(\d+)$`)
+
+// A FrameSequence is a list of numbered png frames, such as the snapshots
+// saved with checkpoints, ordered by their number
+type FrameSequence struct {
+	Filenames []string
+	Numbers   []int
+}
+
+// ListFrameSequence finds the png files in a folder that start with the
+// prefix and end with a number. Other files are skipped.
+func ListFrameSequence(folder string, prefix string) (*FrameSequence, error) {
+	files, err := ioutil.ReadDir(folder)
+	if err != nil {
+		return nil, err
+	}
+	sequence := new(FrameSequence)
+	for _, fileinfo := range files {
+		name := fileinfo.Name()
+		if fileinfo.IsDir() || !strings.HasPrefix(name, prefix) {
+			continue
+		}
+		if !strings.EqualFold(filepath.Ext(name), ".png") {
+			continue
+		}
+		match := frameNumberPattern.FindString(strings.TrimSuffix(name[len(prefix):], filepath.Ext(name)))
+		number, err := strconv.Atoi(match)
+		if err != nil {
+			log.Printf("Skipping '%v', it isn't numbered", name)
+			continue
+		}
+		sequence.Filenames = append(sequence.Filenames, filepath.Join(folder, name))
+		sequence.Numbers = append(sequence.Numbers, number)
+	}
+	sort.Sort(sequence)
+	return sequence, nil
+}
+
+func (sequence *FrameSequence) Len() int { return len(sequence.Filenames) }
+func (sequence *FrameSequence) Swap(i, j int) {
+	sequence.Filenames[i], sequence.Filenames[j] = sequence.Filenames[j], sequence.Filenames[i]
+	sequence.Numbers[i], sequence.Numbers[j] = sequence.Numbers[j], sequence.Numbers[i]
+}
+func (sequence *FrameSequence) Less(i, j int) bool {
+	if sequence.Numbers[i] != sequence.Numbers[j] {
+		return sequence.Numbers[i] < sequence.Numbers[j]
+	}
+	return sequence.Filenames[i] < sequence.Filenames[j]
+}
+
+// Sample keeps at most count evenly spaced frames, always including the first
+// and the last frame
+func (sequence *FrameSequence) Sample(count int) {
+	if count >= sequence.Len() || count < 1 {
+		return
+	}
+	filenames := make([]string, count)
+	numbers := make([]int, count)
+	for i := range filenames {
+		j := sequence.Len() - 1
+		if count > 1 {
+			j = i * (sequence.Len() - 1) / (count - 1)
+		}
+		filenames[i] = sequence.Filenames[j]
+		numbers[i] = sequence.Numbers[j]
+	}
+	sequence.Filenames = filenames
+	sequence.Numbers = numbers
+}
+
+// opaqueFrame returns the frame as an opaque RGBA image starting at 0, 0.
+// Transparent areas become black, like they would in a video.
+func opaqueFrame(frame image.Image) *image.RGBA {
+	bounds := frame.Bounds()
+	if rgba, ok := frame.(*image.RGBA); ok && bounds.Min == image.ZP && rgba.Opaque() {
+		return rgba
+	}
+	opaque := image.NewRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	draw.Draw(opaque, opaque.Bounds(), image.NewUniform(color.Black), image.ZP, draw.Src)
+	draw.Draw(opaque, opaque.Bounds(), frame, bounds.Min, draw.Over)
+	return opaque
+}
+
+// crossfadeFrames blends two frames of the same size, from 0 for the first
+// frame to 1 for the second
+func crossfadeFrames(from *image.RGBA, to *image.RGBA, amount float32) *image.RGBA {
+	blended := image.NewRGBA(from.Bounds())
+	for i := range blended.Pix {
+		a := float32(from.Pix[i])
+		b := float32(to.Pix[i])
+		blended.Pix[i] = uint8(a + (b-a)*amount + 0.5)
+	}
+	return blended
+}
@@ -2,21 +2,19 @@ package main
 
 import (
 	"bytes"
-	"fmt"
+	"context"
 	"image"
 	"image/png"
-	"io/ioutil"
 	"log"
 	"math/rand"
-	"os"
 	"sort"
-	"strconv"
 )
 
 // An Incubator contains a population of Organisms and provides
 // functionality to incrementally improve the population's fitness.
 type Incubator struct {
 	Iteration             int
+	TargetName            string // Filename of the target image, recorded in saved populations. Set before Start.
 	config                *Config
 	target                image.Image
 	topOrganism           *Organism
@@ -25,6 +23,9 @@ type Incubator struct {
 	incomingPatches       []*Patch
 	mutator               *Mutator
 	ranker                *Ranker
+	selection             SelectionStrategy
+	crossover             *Crossover
+	workerPool            *WorkerPool
 	nextOptimization      int // Keeps track of how many iterations before an optimization should kick off.
 	organismRecord        map[string]bool
 	workerCloneChan       chan *Organism
@@ -45,7 +46,15 @@ type Incubator struct {
 	iterateChan           chan VoidCallback
 	getTargetDataChan     chan *TargetImageDataRequest
 	scaleChan             chan *IncubatorScaleRequest
-	optimizeChan          <-chan PatchOperation
+	statsChan             chan *IncubatorStatsRequest
+	stats                 IncubatorStats
+	optimizeChan          <-chan []PatchOperation
+	optimizationStartDiff float32
+	optimizationBestDiff  float32 // Lowest diff of the top organism since the running optimization started
+	optimizationReset     bool    // Whether the running optimization has reset the selection
+	optimizationStartSize int     // Number of instructions when the running optimization started
+	optimizationTried     int
+	done                  chan struct{}
 }
 
 // NewIncubator returns a new `Incubator`
@@ -55,12 +64,19 @@ func NewIncubator(config *Config, target image.Image, mutator *Mutator, ranker *
 	incubator.target = target
 	incubator.mutator = mutator
 	incubator.ranker = ranker
-	incubator.ranker.PrecalculateLabs(target)
+	incubator.ranker.Precalculate(target)
+	selection, err := NewSelectionStrategy(config.SelectionStrategy, config)
+	if err != nil {
+		log.Fatalf("Error creating selection strategy: %v", err.Error())
+	}
+	incubator.selection = selection
+	incubator.crossover = NewCrossover(float32(target.Bounds().Size().X), float32(target.Bounds().Size().Y))
 	incubator.currentGeneration = make([]*Organism, 0, config.MaxPopulation)
 	incubator.currentGenerationMap = make(map[string]*Organism, config.MaxPopulation)
 	incubator.incomingPatches = make([]*Patch, 0, 100)
 
 	incubator.organismRecord = map[string]bool{}
+	incubator.nextOptimization = config.OptimizationFrequency
 
 	// Communication channels
 	incubator.workerCloneChan = make(chan *Organism, config.MaxPopulation)
@@ -80,9 +96,11 @@ func NewIncubator(config *Config, target image.Image, mutator *Mutator, ranker *
 	incubator.loadChan = make(chan *LoadRequest)
 	incubator.iterateChan = make(chan VoidCallback)
 	incubator.getTargetDataChan = make(chan *TargetImageDataRequest)
+	incubator.statsChan = make(chan *IncubatorStatsRequest)
+	incubator.done = make(chan struct{})
 
-	// Start up local worker pool
-	localPool := NewWorkerPool(
+	// Local worker pool, started with the incubator
+	incubator.workerPool = NewWorkerPool(
 		target.Bounds().Size().X,
 		target.Bounds().Size().Y,
 		ranker,
@@ -98,15 +116,26 @@ func NewIncubator(config *Config, target image.Image, mutator *Mutator, ranker *
 		incubator.workerLoadResultChan,
 		config.WorkerCount,
 	)
-	localPool.Start()
 	return incubator
 }
 
-// Start fires up the incubator thread
-func (incubator *Incubator) Start() {
+// Start fires up the incubator thread and its worker pool, which run until
+// the context is cancelled. Nothing may be requested from the incubator after
+// that.
+func (incubator *Incubator) Start(ctx context.Context) {
+	incubator.workerPool.Start(ctx)
 	go func() {
 		for {
 			select {
+			case <-ctx.Done():
+				if incubator.optimizeChan != nil {
+					// Wait for the running optimization to release its organism
+					for range incubator.optimizeChan {
+					}
+					incubator.optimizeChan = nil
+				}
+				close(incubator.done)
+				return
 			case patch := <-incubator.incomingPatchChan:
 				incubator.submitPatch(patch)
 			case organism := <-incubator.incomingOrganismChan:
@@ -117,6 +146,8 @@ func (incubator *Incubator) Start() {
 			case req := <-incubator.getTargetDataChan:
 				data := incubator.getTargetImageData()
 				req.Callback <- data
+			case req := <-incubator.statsChan:
+				req.Callback <- incubator.getIncubatorStats()
 			case cb := <-incubator.iterateChan:
 				incubator.iterate()
 				cb <- nil
@@ -131,6 +162,31 @@ func (incubator *Incubator) Start() {
 	}()
 }
 
+// Done returns a channel that is closed once the incubator has stopped and
+// released the organisms it was optimizing
+func (incubator *Incubator) Done() <-chan struct{} {
+	return incubator.done
+}
+
+// Dispose waits for the incubator to stop and returns its organisms and
+// pending patches to the pool
+func (incubator *Incubator) Dispose() {
+	<-incubator.done
+	incubator.selection.Reset(incubator)
+	for _, organism := range incubator.currentGeneration {
+		incubator.disposeOrganism(organism)
+	}
+	incubator.clearCurrentGeneration()
+	for _, patch := range incubator.incomingPatches {
+		objectPool.ReturnPatch(patch)
+	}
+	incubator.incomingPatches = incubator.incomingPatches[:0]
+	if incubator.topOrganism != nil {
+		incubator.disposeOrganism(incubator.topOrganism)
+		incubator.topOrganism = nil
+	}
+}
+
 // Iterate executes one iteration of the incubator process:
 // * grow
 // * score
@@ -160,60 +216,233 @@ func (incubator *Incubator) iterate() {
 	incubator.applyIncomingPatches()
 	incubator.growPopulation()
 	incubator.scorePopulation()
+	incubator.recordGeneration()
+	incubator.selection.Select(incubator)
+	incubator.optimize()
+	// log.Printf("End iteration %v", incubator.Iteration)
+	incubator.Iteration++
+}
 
-	// If multiple improvements are found, try to apply all of them to the last
-	// top organism so that no improvements are lost.
-	improved := []*Organism{}
+// recordGeneration updates the stats from the scored generation, and reports
+// to the mutator which mutations improved on their parents. Parents are still
+// retained at this point, since selection hasn't run yet.
+func (incubator *Incubator) recordGeneration() {
+	if len(incubator.currentGeneration) == 0 {
+		return
+	}
+	var total float32
 	for _, organism := range incubator.currentGeneration {
-		if organism.Diff < incubator.topOrganism.Diff {
-			// log.Printf("Improved organism: %v - %v, current=%v", organism.Hash(), FormatProgress(organism.Diff), FormatProgress(incubator.topOrganism.Diff))
-			improved = append(improved, organism)
-		} else {
-			// fmt.Printf("%v, ", organism.Diff)
-			// dispose of all organisms/patches that did not lead to improvements
-			incubator.disposeOrganism(organism)
+		total += organism.Diff
+		if len(organism.mutations) > 0 && organism.Parent != nil {
+			incubator.mutator.RecordOutcome(organism, organism.Diff < organism.Parent.Diff)
 		}
 	}
+	// The generation is sorted by diff
+	incubator.stats.MinIterationDiff = incubator.currentGeneration[0].Diff
+	incubator.stats.MaxIterationDiff = incubator.currentGeneration[len(incubator.currentGeneration)-1].Diff
+	incubator.stats.AvgIterationDiff = total / float32(len(incubator.currentGeneration))
+}
+
+// combineImprovements applies the patches of several improved organisms to the
+// top organism, and makes the combined organism the new top organism.
+func (incubator *Incubator) combineImprovements(improved []*Organism) {
+	// log.Printf("Combining improvements and re-scoring")
+	newOrganism := incubator.topOrganism.Clone()
+	newOrganism.AffectedAreas = newOrganism.AffectedAreas[:0]
+	if newOrganism.Patch != nil {
+		objectPool.ReturnPatch(newOrganism.Patch)
+	}
+	patch := objectPool.BorrowPatch()
+	for _, organism := range improved {
+		for _, operation := range organism.Patch.Operations {
+			patch.Operations = append(patch.Operations, operation)
+			newOrganism.AffectedAreas = append(newOrganism.AffectedAreas, operation.Apply(newOrganism)...)
+		}
+		incubator.disposeOrganism(organism)
+	}
+	newOrganism.hash = ""
+	patch.Baseline = incubator.topOrganism.Hash()
+	patch.Target = newOrganism.Hash()
+	newOrganism.Patch = patch
+
+	incubator.currentGeneration = append(incubator.currentGeneration, newOrganism)
+	incubator.currentGenerationMap[newOrganism.Hash()] = newOrganism
+	incubator.organismRecord[newOrganism.Hash()] = true
+
+	incubator.scorePopulation()
+	newTopOrganism := incubator.currentGeneration[0]
 	incubator.clearCurrentGeneration()
+	incubator.setTopOrganism(newTopOrganism, false)
+}
+
+// optimize runs one step of the periodic optimization, which tries to simplify
+// the top organism one instruction at a time. Simplifications are kept as long
+// as the diff doesn't grow beyond the tolerance. Since the diff includes the
+// complexity penalty, organisms over the complexity threshold shrink more.
+func (incubator *Incubator) optimize() {
+	if incubator.config.OptimizationFrequency <= 0 {
+		return
+	}
+	if incubator.optimizeChan == nil {
+		incubator.nextOptimization--
+		if incubator.nextOptimization > 0 || len(incubator.topOrganism.Instructions) == 0 {
+			return
+		}
+		incubator.optimizeChan = incubator.mutator.Optimize(incubator.topOrganism)
+		incubator.optimizationReset = false
+		incubator.optimizationStartDiff = incubator.topOrganism.Diff
+		incubator.optimizationBestDiff = incubator.topOrganism.Diff
+		incubator.optimizationStartSize = len(incubator.topOrganism.Instructions)
+		incubator.optimizationTried = 0
+		log.Printf("Starting optimization of %v instructions", incubator.optimizationStartSize)
+	}
+
+	finished := false
+	for i := 0; i < incubator.config.OptimizationBatchSize; i++ {
+		operations, ok := <-incubator.optimizeChan
+		if !ok {
+			finished = true
+			break
+		}
+		// Every instruction is tried with a single deletion, which may be
+		// followed by a merge
+		if len(operations) == 1 {
+			incubator.optimizationTried++
+		}
+		incubator.addOrganism(incubator.simplifiedOrganism(operations))
+	}
+	if len(incubator.currentGeneration) > 0 {
+		incubator.scorePopulation()
+		incubator.acceptSimplifications()
+	}
+	if finished {
+		log.Printf(
+			"Optimization completed: %v -> %v instructions, diff %v -> %v",
+			incubator.optimizationStartSize, len(incubator.topOrganism.Instructions),
+			incubator.optimizationStartDiff, incubator.topOrganism.Diff)
+		incubator.optimizeChan = nil
+		incubator.nextOptimization = incubator.config.OptimizationFrequency
+		return
+	}
+	progress := float64(incubator.optimizationTried) / float64(incubator.optimizationStartSize) * 100
+	log.Printf("Optimization %.4f%% Completed", progress)
+}
+
+// simplifiedOrganism applies the operations of an optimization candidate to a
+// clone of the top organism. Nil is returned if the operations no longer apply
+// because the instructions they refer to have changed.
+func (incubator *Incubator) simplifiedOrganism(operations []PatchOperation) *Organism {
+	organism := incubator.topOrganism.Clone()
+	organism.AffectedAreas = organism.AffectedAreas[:0]
+	if organism.Patch != nil {
+		objectPool.ReturnPatch(organism.Patch)
+	}
+	patch := objectPool.BorrowPatch()
+	patch.Baseline = incubator.topOrganism.Hash()
+	for _, operation := range operations {
+		affectedAreas := operation.Apply(organism)
+		if len(affectedAreas) == 0 {
+			objectPool.ReturnPatch(patch)
+			organism.Patch = nil
+			incubator.disposeOrganism(organism)
+			return nil
+		}
+		organism.AffectedAreas = append(organism.AffectedAreas, affectedAreas...)
+		patch.Operations = append(patch.Operations, operation)
+	}
+	organism.hash = ""
+	patch.Target = organism.Hash()
+	organism.Patch = patch
+	return organism
+}
 
-	if len(improved) > 0 {
-		if len(improved) == 1 {
-			incubator.setTopOrganism(improved[0], false)
+// acceptSimplifications makes the scored simplifications that are within the
+// tolerance of the best top organism since the optimization started part of
+// the current one. If they don't work together, only the best one is kept.
+// The limit follows improvements, so that simplifications can't undo them,
+// but not the simplifications, so that the whole run stays within the
+// tolerance.
+func (incubator *Incubator) acceptSimplifications() {
+	if incubator.topOrganism.Diff < incubator.optimizationBestDiff {
+		incubator.optimizationBestDiff = incubator.topOrganism.Diff
+	}
+	limit := incubator.optimizationBestDiff + incubator.config.OptimizationTolerance
+	generation := takeCurrentGeneration(incubator)
+	accepted := []*Organism{}
+	for _, organism := range generation {
+		if organism.Diff <= limit {
+			accepted = append(accepted, organism)
 		} else {
-			// log.Printf("Combining improvements and re-scoring")
-			// More than one improvement during the scoring round, so combine the patches together
-			// and apply them to the last topOrganism
-			newOrganism := incubator.topOrganism.Clone()
-			newOrganism.AffectedAreas = newOrganism.AffectedAreas[:0]
-			if newOrganism.Patch != nil {
-				objectPool.ReturnPatch(newOrganism.Patch)
-			}
-			patch := objectPool.BorrowPatch()
-			// operations := []*PatchOperation{}
-			for _, organism := range improved {
-				for _, operation := range organism.Patch.Operations {
-					patch.Operations = append(patch.Operations, operation)
-					newOrganism.AffectedAreas = append(newOrganism.AffectedAreas, operation.Apply(newOrganism)...)
+			incubator.disposeOrganism(organism)
+		}
+	}
+	if len(accepted) == 0 {
+		return
+	}
+	best := accepted[0]
+	if len(accepted) > 1 {
+		combined := incubator.topOrganism.Clone()
+		combined.AffectedAreas = combined.AffectedAreas[:0]
+		if combined.Patch != nil {
+			objectPool.ReturnPatch(combined.Patch)
+		}
+		patch := objectPool.BorrowPatch()
+		patch.Baseline = incubator.topOrganism.Hash()
+		for _, organism := range accepted {
+			for _, operation := range organism.Patch.Operations {
+				// Operations that no longer apply, like merges with an
+				// instruction another simplification deleted, are left out
+				affectedAreas := operation.Apply(combined)
+				if len(affectedAreas) == 0 {
+					continue
 				}
-				incubator.disposeOrganism(organism)
+				patch.Operations = append(patch.Operations, operation)
+				combined.AffectedAreas = append(combined.AffectedAreas, affectedAreas...)
 			}
-			newOrganism.hash = ""
-			patch.Baseline = incubator.topOrganism.Hash()
-			patch.Target = newOrganism.Hash()
-			newOrganism.Patch = patch
-
-			incubator.currentGeneration = append(incubator.currentGeneration, newOrganism)
-			incubator.currentGenerationMap[newOrganism.Hash()] = newOrganism
-			incubator.organismRecord[newOrganism.Hash()] = true
-
-			incubator.scorePopulation()
-			newTopOrganism := incubator.currentGeneration[0]
-			incubator.clearCurrentGeneration()
-			incubator.setTopOrganism(newTopOrganism, false)
+		}
+		combined.hash = ""
+		patch.Target = combined.Hash()
+		combined.Patch = patch
+		incubator.organismRecord[combined.Hash()] = true
+		incubator.currentGeneration = append(incubator.currentGeneration, combined)
+		incubator.currentGenerationMap[combined.Hash()] = combined
+		incubator.scorePopulation()
+		incubator.clearCurrentGeneration()
+		if combined.Diff <= limit {
+			best = combined
+		} else {
+			incubator.disposeOrganism(combined)
 		}
 	}
-	// log.Printf("End iteration %v", incubator.Iteration)
-	incubator.Iteration++
+	for _, organism := range accepted {
+		if organism != best {
+			incubator.disposeOrganism(organism)
+		}
+	}
+
+	// Organisms retained from before the optimization are more complex than
+	// the new top organism, and mustn't replace it just because their diff is
+	// slightly lower. Later ones descend from the simplified organisms, so the
+	// selection keeps its state for the rest of the optimization.
+	if !incubator.optimizationReset {
+		incubator.selection.Reset(incubator)
+		incubator.optimizationReset = true
+	}
+	incubator.setTopOrganism(best, false)
+}
+
+// stopOptimization abandons the running optimization, if any
+func (incubator *Incubator) stopOptimization() {
+	if incubator.optimizeChan == nil {
+		return
+	}
+	// Let the producer finish so that it releases its organism
+	go func(stream <-chan []PatchOperation) {
+		for range stream {
+		}
+	}(incubator.optimizeChan)
+	incubator.optimizeChan = nil
+	incubator.nextOptimization = incubator.config.OptimizationFrequency
 }
 
 func (incubator *Incubator) clearCurrentGeneration() {
@@ -255,15 +484,20 @@ func (incubator *Incubator) Save(filename string) {
 }
 
 func (incubator *Incubator) save(filename string) {
-	file, err := os.Create(filename)
-	if err != nil {
-		log.Fatalf("Error saving incubator: %v", err.Error())
-	}
-	defer file.Close()
-	file.WriteString(fmt.Sprintf("%v\n", incubator.Iteration))
 	incubator.workerSaveChan <- incubator.topOrganism
 	saved := <-incubator.workerSaveResultChan
-	file.Write(saved)
+	size := incubator.target.Bounds().Size()
+	populationFile := NewPopulationFile(size.X, size.Y, incubator.config)
+	populationFile.Header.Iteration = incubator.Iteration
+	populationFile.Header.Target = incubator.TargetName
+	populationFile.Header.Diff = incubator.topOrganism.Diff
+	populationFile.Header.RawDiff = incubator.topOrganism.RawDiff
+	populationFile.Organisms = append(populationFile.Organisms, saved)
+	err := SaveCheckpoint(filename, populationFile, incubator.config.CheckpointHistory)
+	if err != nil {
+		// The previous checkpoint is still intact, so keep evolving
+		log.Printf("Error saving incubator: %v", err.Error())
+	}
 }
 
 // Load loads a population from the specified filename
@@ -279,22 +513,32 @@ func (incubator *Incubator) Load(filename string) {
 
 func (incubator *Incubator) load(filename string) {
 	incubator.organismRecord = map[string]bool{}
-	data, err := ioutil.ReadFile(filename)
+	populationFile, _, err := LoadCheckpoint(filename)
 	if err != nil {
 		log.Fatalf("Error loading incubator: %v", err.Error())
 	}
-	lines := bytes.Split(data, []byte("\n"))
-	header := string(bytes.TrimSpace(lines[0]))
-	iteration, _ := strconv.ParseInt(header, 10, 32)
-	incubator.Iteration = int(iteration)
+	header := populationFile.Header
+	size := incubator.target.Bounds().Size()
+	if header.HasDimensions() && (header.Width != size.X || header.Height != size.Y) {
+		log.Printf("Warning: population was evolved at %vx%v but the target is %vx%v", header.Width, header.Height, size.X, size.Y)
+	}
+	if header.ConfigHash != "" && header.ConfigHash != ConfigHash(incubator.config) {
+		log.Printf("Config has changed since the population was saved")
+	}
+	incubator.Iteration = header.Iteration
+	if len(populationFile.Organisms) == 0 {
+		panic("No organisms found in population file")
+	}
 	// TODO: refactor this for a single organism
-	incubator.workerLoadChan <- lines[1]
+	incubator.workerLoadChan <- populationFile.Organisms[0]
 	organism := <-incubator.workerLoadResultChan
 	if organism == nil {
 		panic("Loaded nil organism from file")
 	}
 	organism.Diff = -1
 	organism.CleanupInstructions()
+	incubator.selection.Reset(incubator)
+	incubator.stopOptimization()
 	incubator.topOrganism = organism
 	// add for scoring
 	incubator.addOrganism(organism)
@@ -337,7 +581,9 @@ func (incubator *Incubator) scorePopulation() {
 	}
 	for range incubator.currentGeneration {
 		workItemResult := <-incubator.workerRankResultChan
-		incubator.currentGenerationMap[workItemResult.ID].Diff = workItemResult.Diff
+		organism := incubator.currentGenerationMap[workItemResult.ID]
+		organism.Diff = workItemResult.Diff
+		organism.RawDiff = workItemResult.RawDiff
 	}
 
 	sort.Sort(OrganismList(incubator.currentGeneration))
@@ -371,10 +617,17 @@ func (incubator *Incubator) growPopulation() {
 	}
 
 	for len(incubator.currentGeneration) < incubator.config.MaxPopulation {
+		clones := 0
 		for i := len(incubator.currentGeneration); i < incubator.config.MaxPopulation; i++ {
-			incubator.workerCloneChan <- incubator.topOrganism
+			child := incubator.crossoverChild()
+			if child != nil {
+				incubator.addOrganism(child)
+				continue
+			}
+			incubator.workerCloneChan <- incubator.selection.Parent(incubator)
+			clones++
 		}
-		for i := len(incubator.currentGeneration); i < incubator.config.MaxPopulation; i++ {
+		for i := 0; i < clones; i++ {
 			organism := <-incubator.workerCloneResultChan
 			incubator.applyMutations(organism)
 			incubator.addOrganism(organism)
@@ -389,21 +642,69 @@ func (incubator *Incubator) growPopulation() {
 	}
 }
 
+// crossoverChild breeds a child from two retained parents, if the selection
+// strategy keeps several parents and the crossover rate allows it. Nil is
+// returned when the child should be created by mutation instead.
+func (incubator *Incubator) crossoverChild() *Organism {
+	if incubator.config.CrossoverRate <= 0 || rand.Float32() >= incubator.config.CrossoverRate {
+		return nil
+	}
+	selector, ok := incubator.selection.(ParentPairSelector)
+	if !ok {
+		return nil
+	}
+	parent1, parent2, ok := selector.ParentPair(incubator)
+	if !ok {
+		return nil
+	}
+	child := incubator.crossover.Breed(parent1, parent2)
+	if child != nil && incubator.organismRecord[child.Hash()] {
+		// Already tried, mutate instead so that the generation keeps growing
+		incubator.disposeOrganism(child)
+		return nil
+	}
+	return child
+}
+
+// applyMutations applies between MinMutations and MaxMutations operations to
+// the organism, and records them as a single patch from its parent. Several
+// operations at once let organisms escape local minima that no single
+// operation improves on.
 func (incubator *Incubator) applyMutations(organism *Organism) {
 	baseline := organism.Hash()
-	operation := incubator.mutator.Mutate(organism)
-	organism.hash = ""
 	if organism.Patch != nil {
 		objectPool.ReturnPatch(organism.Patch)
 	}
 	organism.Patch = objectPool.BorrowPatch()
-	organism.Patch.Operations = append(organism.Patch.Operations, operation)
+	affectedAreas := make([]Rect, 0, len(organism.AffectedAreas))
+	count := incubator.mutationCount()
+	for i := 0; i < count; i++ {
+		operation := incubator.mutator.Mutate(organism)
+		organism.Patch.Operations = append(organism.Patch.Operations, operation)
+		affectedAreas = append(affectedAreas, organism.AffectedAreas...)
+	}
+	organism.AffectedAreas = append(organism.AffectedAreas[:0], affectedAreas...)
+	organism.hash = ""
 	organism.Patch.Baseline = baseline
 	organism.Patch.Target = organism.Hash()
 }
 
+// mutationCount returns a random number of mutations to apply to a child
+func (incubator *Incubator) mutationCount() int {
+	min := incubator.config.MinMutations
+	if min < 1 {
+		min = 1
+	}
+	max := incubator.config.MaxMutations
+	if max <= min {
+		return min
+	}
+	return min + rand.Intn(max-min+1)
+}
+
 func (incubator *Incubator) createRandomOrganism() *Organism {
 	organism := objectPool.BorrowOrganism()
+	organism.Background = AverageColor(incubator.target)
 	numInstructions := int(rand.Int31n(int32(incubator.config.MaxComplexity-incubator.config.MinComplexity)) + int32(incubator.config.MinComplexity))
 	for i := 0; i < numInstructions; i++ {
 		organism.Instructions = append(organism.Instructions, incubator.mutator.RandomInstruction())
@@ -445,6 +746,10 @@ func (incubator *Incubator) setTopOrganism(organism *Organism, requireScoring bo
 	}
 	incubator.topOrganism = organism
 	if requireScoring {
+		// The organism came from outside, so retained organisms and the
+		// running optimization are out of date
+		incubator.selection.Reset(incubator)
+		incubator.stopOptimization()
 		incubator.currentGeneration = append(incubator.currentGeneration, organism)
 		incubator.currentGenerationMap[organism.Hash()] = organism
 		incubator.scorePopulation()
@@ -452,6 +757,34 @@ func (incubator *Incubator) setTopOrganism(organism *Organism, requireScoring bo
 	}
 }
 
+// promoteOrganism makes a clone of an organism retained by the selection the
+// new top organism. The organism was bred from its selection parent, so its
+// patch is rebased on the previous top organism before it can be exported.
+func (incubator *Incubator) promoteOrganism(organism *Organism) {
+	clone := organism.Clone()
+	if clone.Patch != nil {
+		objectPool.ReturnPatch(clone.Patch)
+	}
+	clone.Patch = DiffOrganisms(incubator.topOrganism, clone)
+	incubator.setTopOrganism(clone, false)
+}
+
+// GetIncubatorStats describes the latest iteration of the incubator
+func (incubator *Incubator) GetIncubatorStats() *IncubatorStats {
+	callback := make(chan *IncubatorStats)
+	incubator.statsChan <- &IncubatorStatsRequest{
+		Callback: callback,
+	}
+	return <-callback
+}
+
+func (incubator *Incubator) getIncubatorStats() *IncubatorStats {
+	stats := incubator.stats
+	stats.Selection = incubator.selection.Status()
+	stats.MutationShares = incubator.mutator.MutationShares()
+	return &stats
+}
+
 // GetOrganismRequest is a request for the top organism in an incubator.
 // It is used to seed external worker processes.
 type GetOrganismRequest struct {
@@ -478,15 +811,17 @@ type TargetImageDataRequest struct {
 	Callback chan<- []byte
 }
 
+// IncubatorStats describes the latest iteration of an incubator
 type IncubatorStats struct {
-	MaxDiff          float32
-	AvgDiff          float32
-	MinDiff          float32
+	// Diffs of the latest scored generation
 	MaxIterationDiff float32
 	AvgIterationDiff float32
 	MinIterationDiff float32
+	Selection        string             // Status of the selection strategy
+	MutationShares   map[string]float32 // Probability of each mutation operation being chosen
 }
 
+// IncubatorStatsRequest is a request for the stats of an incubator
 type IncubatorStatsRequest struct {
 	Callback chan<- *IncubatorStats
 }
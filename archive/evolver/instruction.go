@@ -14,6 +14,7 @@ type Instruction interface {
 	Clone() Instruction
 	Hash() string
 	Scale(factor float32) Instruction
+	Translate(dx float32, dy float32) Instruction
 	Bounds() Rect
 }
 
@@ -32,10 +33,11 @@ func (lst InstructionList) Insert(i int, item Instruction) []Instruction {
 	return append(a[:i], append([]Instruction{item}, a[i:]...)...)
 }
 
-// LoadInstruction will load a previously saved Instruction
-// TODO: engineer a more plugin-friendly way of hydrating instructions
-func LoadInstruction(instructionType string, data []byte) Instruction {
-	instruction := objectPool.BorrowInstruction(instructionType)
-	instruction.Load(data)
-	return instruction
+// LoadInstruction will load a previously saved Instruction of a registered type
+func LoadInstruction(instructionType string, data []byte) (Instruction, error) {
+	registered, err := GetInstructionType(instructionType)
+	if err != nil {
+		return nil, err
+	}
+	return registered.Load(data), nil
 }
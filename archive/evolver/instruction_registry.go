@@ -0,0 +1,64 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+
+	pool "github.com/jolestar/go-commons-pool"
+)
+
+// An InstructionType declares everything needed to evolve, persist and export
+// one kind of Instruction. Types register themselves with
+// RegisterInstructionType, usually from an init function next to the
+// Instruction implementation.
+type InstructionType struct {
+	// Name is the type name instructions are saved with
+	Name string
+	// NewFactory creates a factory for pooling instructions of this type
+	NewFactory func() pool.PooledObjectFactory
+	// NewMutator creates a mutator for instructions of this type on a canvas
+	// of the given size
+	NewMutator func(config *Config, imageWidth float32, imageHeight float32) InstructionMutator
+	// ExportSVG returns an svg element that draws the instruction
+	ExportSVG func(instruction Instruction) string
+	// Merge optionally returns a new instruction that approximates two
+	// instructions of this type, for optimization runs. It may be nil.
+	Merge func(a Instruction, b Instruction) Instruction
+}
+
+// Load borrows an instruction of this type from the object pool and loads it
+// from its persisted form
+func (instructionType *InstructionType) Load(data []byte) Instruction {
+	instruction := objectPool.BorrowInstruction(instructionType.Name)
+	instruction.Load(data)
+	return instruction
+}
+
+var instructionTypes = map[string]*InstructionType{}
+
+// RegisterInstructionType makes an instruction type available by name
+func RegisterInstructionType(instructionType *InstructionType) {
+	if _, has := instructionTypes[instructionType.Name]; has {
+		panic(fmt.Sprintf("Instruction type '%v' registered twice", instructionType.Name))
+	}
+	instructionTypes[instructionType.Name] = instructionType
+}
+
+// GetInstructionType returns the registered instruction type with the specified name
+func GetInstructionType(name string) (*InstructionType, error) {
+	instructionType, has := instructionTypes[name]
+	if !has {
+		return nil, fmt.Errorf("Unknown instruction type '%v'", name)
+	}
+	return instructionType, nil
+}
+
+// InstructionTypeNames returns the names of all registered instruction types, sorted
+func InstructionTypeNames() []string {
+	names := make([]string, 0, len(instructionTypes))
+	for name := range instructionTypes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
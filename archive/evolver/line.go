@@ -2,18 +2,38 @@ package main
 
 import (
 	"crypto/md5"
-	"encoding/base64"
 	"encoding/json"
 	"fmt"
 	"image/color"
 	"math"
 
 	"github.com/fogleman/gg"
+	pool "github.com/jolestar/go-commons-pool"
 )
 
 // TypeLine is a constant describing the "line" type
 const TypeLine = "line"
 
+func init() {
+	RegisterInstructionType(&InstructionType{
+		Name:       TypeLine,
+		NewFactory: func() pool.PooledObjectFactory { return NewLineFactory() },
+		NewMutator: func(config *Config, imageWidth float32, imageHeight float32) InstructionMutator {
+			return NewLineMutator(config, imageWidth, imageHeight)
+		},
+		ExportSVG: func(instruction Instruction) string {
+			line := instruction.(*Line)
+			return fmt.Sprintf(
+				"<line x1=\"%v\" y1=\"%v\" x2=\"%v\" y2=\"%v\" stroke=\"%v\" stroke-width=\"%v\" stroke-linecap=\"round\"%v/>",
+				line.StartX, line.StartY, line.EndX, line.EndY, SaveColorHex(line.Color), line.Width,
+				svgOpacityAttribute("stroke-opacity", line.Opacity))
+		},
+		Merge: func(a Instruction, b Instruction) Instruction {
+			return a.(*Line).Merge(b.(*Line))
+		},
+	})
+}
+
 // Line represents an instruction that draws a line between two points
 type Line struct {
 	StartX     float32
@@ -21,14 +41,17 @@ type Line struct {
 	EndX       float32
 	EndY       float32
 	Width      float32
-	Color      color.Color `json:"-"`
-	SavedColor *SavedColor
+	Color      *color.RGBA `json:"-"`
+	SavedColor *SavedColor `json:",omitempty"`
+	HexColor   string      `json:",omitempty"`
+	Opacity    float32     // 0 is fully transparent, 1 is fully opaque
 	hash       string
+	bounds     Rect // Cache bounds
 }
 
 // Execute draws a line between two points
 func (line *Line) Execute(ctx *gg.Context) {
-	ctx.SetColor(line.Color)
+	ctx.SetColor(ColorWithOpacity(line.Color, line.Opacity))
 	ctx.SetLineWidth(float64(line.Width))
 	ctx.DrawLine(float64(line.StartX), float64(line.StartY), float64(line.EndX), float64(line.EndY))
 	ctx.Stroke()
@@ -36,25 +59,46 @@ func (line *Line) Execute(ctx *gg.Context) {
 
 func (line *Line) Scale(factor float32) Instruction {
 	clone := line.Clone().(*Line)
-	line.StartX *= factor
-	line.StartY *= factor
-	line.EndX *= factor
-	line.EndY *= factor
-	line.Width *= factor
+	clone.StartX *= factor
+	clone.StartY *= factor
+	clone.EndX *= factor
+	clone.EndY *= factor
+	clone.Width *= factor
+	clone.bounds = Rect{}
+	clone.hash = ""
+	return clone
+}
+
+// Translate returns a copy of the line moved by dx, dy
+func (line *Line) Translate(dx float32, dy float32) Instruction {
+	clone := line.Clone().(*Line)
+	clone.StartX += dx
+	clone.StartY += dy
+	clone.EndX += dx
+	clone.EndY += dy
+	clone.bounds = Rect{}
+	clone.hash = ""
 	return clone
 }
 
 // Save saves the line to a persisted form
 func (line *Line) Save() []byte {
-	line.SavedColor = SaveColor(line.Color)
+	line.HexColor = SaveColorHex(line.Color)
+	line.SavedColor = nil
 	data, _ := json.Marshal(line)
 	return data
 }
 
 // Load loads the line from a persisted form
 func (line *Line) Load(data []byte) {
+	line.Opacity = legacyOpacity
 	json.Unmarshal(data, line)
-	line.Color = LoadColor(line.SavedColor)
+	line.Opacity = clampOpacity(line.Opacity)
+	if line.SavedColor != nil {
+		line.Color = LoadColor(line.SavedColor)
+	} else {
+		line.Color = LoadColorHex(line.HexColor)
+	}
 }
 
 // Type returns "line" type
@@ -64,33 +108,73 @@ func (line *Line) Type() string {
 
 // Clone returns a deep copy of the instruction
 func (line *Line) Clone() Instruction {
-	// Cheap deep copy!
-	newLine := *line
-	return &newLine
+	newLine := objectPool.BorrowInstruction(TypeLine).(*Line)
+	// cheap deep copy of color
+	newColor := *line.Color
+	newLine.Color = &newColor
+	newLine.HexColor = line.HexColor
+	newLine.Opacity = line.Opacity
+	newLine.SavedColor = line.SavedColor
+	newLine.StartX = line.StartX
+	newLine.StartY = line.StartY
+	newLine.EndX = line.EndX
+	newLine.EndY = line.EndY
+	newLine.Width = line.Width
+	newLine.hash = line.hash
+	newLine.bounds = line.bounds
+	return newLine
+}
+
+// Merge returns a new line between the two lines, as wide as the wider of
+// them, in their average color
+func (line *Line) Merge(other *Line) *Line {
+	merged := line.Clone().(*Line)
+	merged.StartX = (line.StartX + other.StartX) / 2
+	merged.StartY = (line.StartY + other.StartY) / 2
+	merged.EndX = (line.EndX + other.EndX) / 2
+	merged.EndY = (line.EndY + other.EndY) / 2
+	merged.Width = float32(math.Max(float64(line.Width), float64(other.Width)))
+	merged.Color = MixColors(line.Color, other.Color)
+	merged.Opacity = (line.Opacity + other.Opacity) / 2
+	merged.RecalculateHash()
+	return merged
 }
 
 // Hash returns a (probably) unique hash that represents this particular instruction
 func (line *Line) Hash() string {
 	if line.hash == "" {
-		r, g, b, _ := line.Color.RGBA()
-		value := fmt.Sprintf(
-			"%v%v%v%v%v%v%v%v", line.StartX, line.StartY, line.EndX, line.EndY, line.Width, r, g, b)
-		hasher := md5.New()
-		line.hash = base64.StdEncoding.EncodeToString(hasher.Sum([]byte(value)))
+		buf := objectPool.BorrowByteBuffer()
+		buf.WriteString(SaveColorHex(line.Color))
+		buf.WriteString("|")
+		if line.Opacity < 1 {
+			buf.WriteString(fmt.Sprintf("%.4f|", line.Opacity))
+		}
+		buf.WriteString(fmt.Sprintf(
+			"%.4f|%.4f|%.4f|%.4f|%.4f|", line.StartX, line.StartY, line.EndX, line.EndY, line.Width))
+		line.hash = fmt.Sprintf("%x", md5.Sum(buf.Bytes()))
+		objectPool.ReturnByteBuffer(buf)
 	}
 	return line.hash
 }
 
-// Bounds returns the rectangular bounds of the line
+// RecalculateHash clears the cached hash and bounds after the line has been modified
+func (line *Line) RecalculateHash() {
+	line.hash = ""
+	line.bounds = Rect{}
+	line.Hash()
+}
+
+// Bounds returns the rectangular bounds of the line, including its width
 func (line *Line) Bounds() Rect {
-	left := math.Min(float64(line.StartX), float64(line.EndX))
-	right := math.Max(float64(line.StartX), float64(line.EndX))
-	top := math.Min(float64(line.StartY), float64(line.EndY))
-	bottom := math.Max(float64(line.StartY), float64(line.EndY))
-	return Rect{
-		Left:   float32(left),
-		Right:  float32(right),
-		Top:    float32(top),
-		Bottom: float32(bottom),
+	if line.bounds != (Rect{}) {
+		return line.bounds
+	}
+	padding := line.Width / 2
+	line.bounds = Rect{
+		Left:   float32(math.Min(float64(line.StartX), float64(line.EndX))) - padding,
+		Right:  float32(math.Max(float64(line.StartX), float64(line.EndX))) + padding,
+		Top:    float32(math.Min(float64(line.StartY), float64(line.EndY))) - padding,
+		Bottom: float32(math.Max(float64(line.StartY), float64(line.EndY))) + padding,
 	}
+	return line.bounds
 }
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"context"
+
+	pool "github.com/jolestar/go-commons-pool"
+)
+
+// LineFactory helps pool Lines
+type LineFactory struct{}
+
+// NewLineFactory creates a new LineFactory
+func NewLineFactory() *LineFactory {
+	return &LineFactory{}
+}
+
+// MakeObject creates new Lines
+func (f *LineFactory) MakeObject(ctx context.Context) (*pool.PooledObject, error) {
+	return pool.NewPooledObject(&Line{}), nil
+}
+
+// DestroyObject destroys objects
+func (f *LineFactory) DestroyObject(ctx context.Context, object *pool.PooledObject) error {
+	return nil
+}
+
+// ValidateObject validates objects
+func (f *LineFactory) ValidateObject(ctx context.Context, object *pool.PooledObject) bool {
+	// TODO: should any validation be performed?
+	return true
+}
+
+// ActivateObject activates objects
+func (f *LineFactory) ActivateObject(ctx context.Context, object *pool.PooledObject) error {
+	return nil
+}
+
+// PassivateObject resets a Line to its default state.
+func (f *LineFactory) PassivateObject(ctx context.Context, object *pool.PooledObject) error {
+	obj := object.Object.(*Line)
+	obj.bounds = Rect{}
+	obj.hash = ""
+	obj.HexColor = ""
+	obj.Opacity = 0
+	obj.Color = nil
+	obj.StartX = 0
+	obj.StartY = 0
+	obj.EndX = 0
+	obj.EndY = 0
+	obj.Width = 0
+	obj.SavedColor = nil
+	return nil
+}
@@ -68,7 +68,7 @@ func (mut *LineMutator) MutateInstruction(instruction Instruction) {
 	// color
 	// coordinates
 	// width
-	switch rand.Int31n(3) {
+	switch chooseWeighted(mut.config.ColorMutationWeight, mut.config.CoordinateMutationWeight, mut.config.ShapeMutationWeight) {
 	case 0:
 		mut.mutateColor(line)
 	case 1:
@@ -76,6 +76,7 @@ func (mut *LineMutator) MutateInstruction(instruction Instruction) {
 	default:
 		mut.mutateLineWidth(line)
 	}
+	line.RecalculateHash()
 }
 
 func (mut *LineMutator) InstructionType() string {
@@ -87,11 +88,13 @@ func (mut *LineMutator) InstructionType() string {
 // Red, Green, Blue
 
 func (mut *LineMutator) mutateColor(line *Line) {
-	switch rand.Int31n(3) {
+	switch rand.Int31n(4) {
 	case 0:
 		mut.mutateHue(line)
 	case 1:
 		mut.mutateSaturation(line)
+	case 2:
+		mutateOpacity(&line.Opacity, mut.config)
 	default:
 		mut.mutateLightness(line)
 	}
@@ -100,19 +103,19 @@ func (mut *LineMutator) mutateColor(line *Line) {
 func (mut *LineMutator) mutateHue(line *Line) {
 	hue, sat, lightness := MakeColor(line.Color).Hsl()
 	newHue := mut.mutateValue(0, 360, mut.config.MinHueMutation, mut.config.MaxHueMutation, float32(hue))
-	line.Color = colorful.Hsl(float64(newHue), sat, lightness)
+	line.Color = LoadColorHex(SaveColorHex(colorful.Hsl(float64(newHue), sat, lightness)))
 }
 
 func (mut *LineMutator) mutateSaturation(line *Line) {
 	hue, sat, lightness := MakeColor(line.Color).Hsl()
 	newSat := mut.mutateValue(0, 1, mut.config.MinSaturationMutation, mut.config.MaxSaturationMutation, float32(sat))
-	line.Color = colorful.Hsl(hue, float64(newSat), lightness)
+	line.Color = LoadColorHex(SaveColorHex(colorful.Hsl(hue, float64(newSat), lightness)))
 }
 
 func (mut *LineMutator) mutateLightness(line *Line) {
 	hue, sat, lightness := MakeColor(line.Color).Hsl()
 	newLightness := mut.mutateValue(0, 1, mut.config.MinValueMutation, mut.config.MaxValueMutation, float32(lightness))
-	line.Color = colorful.Hsl(hue, sat, float64(newLightness))
+	line.Color = LoadColorHex(SaveColorHex(colorful.Hsl(hue, sat, float64(newLightness))))
 }
 
 // Mutate Brush Size
@@ -157,19 +160,20 @@ func (mut *LineMutator) RandomInstruction() Instruction {
 	startY := rand.Float32() * mut.imageHeight
 	endY := float32(math.Sin(float64(angle)))*lineLength + startY
 	endX := float32(math.Cos(float64(angle)))*lineLength + startX
-	return &Line{
-		StartX: startX,
-		StartY: startY,
-		EndX:   endX,
-		EndY:   endY,
-		Color: &color.RGBA{
-			A: 255,
-			G: uint8(rand.Int31n(255)),
-			B: uint8(rand.Int31n(255)),
-			R: uint8(rand.Int31n(255)),
-		},
-		Width: lineWidth,
+	line := objectPool.BorrowInstruction(TypeLine).(*Line)
+	line.StartX = startX
+	line.StartY = startY
+	line.EndX = endX
+	line.EndY = endY
+	line.Color = &color.RGBA{
+		A: 255,
+		G: uint8(rand.Int31n(255)),
+		B: uint8(rand.Int31n(255)),
+		R: uint8(rand.Int31n(255)),
 	}
+	line.Opacity = 1
+	line.Width = lineWidth
+	return line
 }
 
 func (mut *LineMutator) mutateValue(min float32, max float32, minDelta float32, maxDelta float32, value float32) float32 {
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"fmt"
+	"image"
+)
+
+const (
+	// MetricCIE76 - euclidean distance in the Lab color space
+	MetricCIE76 = "cie76"
+	// MetricCIEDE2000 - perceptual CIEDE2000 color difference
+	MetricCIEDE2000 = "ciede2000"
+	// MetricSSIM - structural similarity of luminance
+	MetricSSIM = "ssim"
+	// MetricMultiScale - Lab distance averaged over several blur levels
+	MetricMultiScale = "multiscale"
+	// MetricEdgeWeighted - Lab distance weighted towards edges in the target
+	MetricEdgeWeighted = "edge"
+)
+
+// A FitnessMetric measures how different a rendered image is from the target,
+// pixel by pixel. Per-pixel diffs are stored in a DiffMap so that only the
+// areas affected by a mutation need to be recalculated.
+// Implementations are shared between workers and must be safe for concurrent
+// use after Precalculate.
+type FitnessMetric interface {
+	Name() string
+	// MaxDiff is the average diff of a completely wrong image. It is used
+	// to express diffs as a percentage.
+	MaxDiff() float32
+	// Radius is how many pixels beyond a changed area can have their diff
+	// affected by the change.
+	Radius() int
+	// Precalculate prepares the metric to compare images against the target
+	Precalculate(target image.Image)
+	// Diff calculates the diffs of the image against the target for pixels in
+	// [left, right) x [top, bottom) and records them in the diffMap.
+	Diff(image image.Image, left int, top int, right int, bottom int, diffMap *DiffMap)
+}
+
+// NewFitnessMetric returns the metric with the specified name
+func NewFitnessMetric(name string, config *Config) (FitnessMetric, error) {
+	switch name {
+	case MetricCIE76, "":
+		return NewCIE76Metric(), nil
+	case MetricCIEDE2000:
+		return NewCIEDE2000Metric(), nil
+	case MetricSSIM:
+		return NewSSIMMetric(), nil
+	case MetricMultiScale:
+		return NewMultiScaleMetric(), nil
+	case MetricEdgeWeighted:
+		return NewEdgeWeightedMetric(config.EdgeWeight), nil
+	}
+	return nil, fmt.Errorf("Unknown fitness metric '%v'", name)
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"image"
+	"math"
+)
+
+// cie76MaxDiff is the distance between black and white
+const cie76MaxDiff = 94.0
+
+// CIE76Metric compares pixels by their euclidean distance in the Lab color space.
+type CIE76Metric struct {
+	precalculatedImage [][]*Lab
+}
+
+// NewCIE76Metric returns a new `CIE76Metric`
+func NewCIE76Metric() *CIE76Metric {
+	return &CIE76Metric{}
+}
+
+func (metric *CIE76Metric) Name() string {
+	return MetricCIE76
+}
+
+func (metric *CIE76Metric) MaxDiff() float32 {
+	return cie76MaxDiff
+}
+
+func (metric *CIE76Metric) Radius() int {
+	return 0
+}
+
+// Precalculate pre-calculates Lab colors for the target to avoid
+// recomputing them on each comparison
+func (metric *CIE76Metric) Precalculate(target image.Image) {
+	metric.precalculatedImage = precalculateLabs(target, getLab)
+}
+
+func (metric *CIE76Metric) Diff(image image.Image, left int, top int, right int, bottom int, diffMap *DiffMap) {
+	// Keep a cache of color mappings for this image
+	cache := map[uint32]*Lab{}
+	for x := left; x < right; x++ {
+		for y := top; y < bottom; y++ {
+			lab2 := cachedLab(cache, image.At(x, y), getLab)
+			diffMap.SetDiff(x, y, colorDistance(metric.precalculatedImage[x][y], lab2))
+		}
+	}
+}
+
+// Calculates the distance between two colors using the Lab color space
+func colorDistance(lab1 *Lab, lab2 *Lab) float32 {
+	lDiff := lab2.l - lab1.l
+	lDiff = lDiff * lDiff
+	aDiff := lab2.a - lab1.a
+	aDiff = aDiff * aDiff
+	bDiff := lab2.b - lab1.b
+	bDiff = bDiff * bDiff
+	return float32(math.Sqrt(float64(lDiff) + float64(aDiff) + float64(bDiff)))
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"image"
+	"math"
+)
+
+// ciede2000MaxDiff is the difference between black and white
+const ciede2000MaxDiff = 100.0
+
+// pow25to7 is 25^7, used in the chroma compensation terms
+const pow25to7 = 6103515625.0
+
+// CIEDE2000Metric compares pixels using the CIEDE2000 color difference, which
+// corrects CIE76 for the eye's uneven sensitivity to hue, chroma and lightness.
+type CIEDE2000Metric struct {
+	precalculatedImage [][]*Lab
+}
+
+// NewCIEDE2000Metric returns a new `CIEDE2000Metric`
+func NewCIEDE2000Metric() *CIEDE2000Metric {
+	return &CIEDE2000Metric{}
+}
+
+func (metric *CIEDE2000Metric) Name() string {
+	return MetricCIEDE2000
+}
+
+func (metric *CIEDE2000Metric) MaxDiff() float32 {
+	return ciede2000MaxDiff
+}
+
+func (metric *CIEDE2000Metric) Radius() int {
+	return 0
+}
+
+func (metric *CIEDE2000Metric) Precalculate(target image.Image) {
+	metric.precalculatedImage = precalculateLabs(target, getStandardLab)
+}
+
+func (metric *CIEDE2000Metric) Diff(image image.Image, left int, top int, right int, bottom int, diffMap *DiffMap) {
+	cache := map[uint32]*Lab{}
+	for x := left; x < right; x++ {
+		for y := top; y < bottom; y++ {
+			lab2 := cachedLab(cache, image.At(x, y), getStandardLab)
+			diffMap.SetDiff(x, y, ciede2000(metric.precalculatedImage[x][y], lab2))
+		}
+	}
+}
+
+// ciede2000 calculates the CIEDE2000 difference between two standard Lab colors.
+// See http://www2.ece.rochester.edu/~gsharma/ciede2000/ciede2000noteCRNA.pdf
+func ciede2000(lab1 *Lab, lab2 *Lab) float32 {
+	l1, a1, b1 := float64(lab1.l), float64(lab1.a), float64(lab1.b)
+	l2, a2, b2 := float64(lab2.l), float64(lab2.a), float64(lab2.b)
+
+	cBar := (math.Hypot(a1, b1) + math.Hypot(a2, b2)) / 2
+	cBar7 := math.Pow(cBar, 7)
+	g := 0.5 * (1 - math.Sqrt(cBar7/(cBar7+pow25to7)))
+	a1p := (1 + g) * a1
+	a2p := (1 + g) * a2
+	c1p := math.Hypot(a1p, b1)
+	c2p := math.Hypot(a2p, b2)
+	h1p := hueDegrees(b1, a1p)
+	h2p := hueDegrees(b2, a2p)
+
+	dLp := l2 - l1
+	dCp := c2p - c1p
+	dhp := 0.0
+	if c1p*c2p != 0 {
+		dhp = h2p - h1p
+		if dhp > 180 {
+			dhp -= 360
+		} else if dhp < -180 {
+			dhp += 360
+		}
+	}
+	dHp := 2 * math.Sqrt(c1p*c2p) * math.Sin(radians(dhp/2))
+
+	lBarp := (l1 + l2) / 2
+	cBarp := (c1p + c2p) / 2
+	hBarp := h1p + h2p
+	if c1p*c2p != 0 {
+		if math.Abs(h1p-h2p) <= 180 {
+			hBarp = (h1p + h2p) / 2
+		} else if h1p+h2p < 360 {
+			hBarp = (h1p + h2p + 360) / 2
+		} else {
+			hBarp = (h1p + h2p - 360) / 2
+		}
+	}
+	t := 1 -
+		0.17*math.Cos(radians(hBarp-30)) +
+		0.24*math.Cos(radians(2*hBarp)) +
+		0.32*math.Cos(radians(3*hBarp+6)) -
+		0.20*math.Cos(radians(4*hBarp-63))
+	dTheta := 30 * math.Exp(-math.Pow((hBarp-275)/25, 2))
+	cBarp7 := math.Pow(cBarp, 7)
+	rc := 2 * math.Sqrt(cBarp7/(cBarp7+pow25to7))
+	lBarp50 := (lBarp - 50) * (lBarp - 50)
+	sl := 1 + 0.015*lBarp50/math.Sqrt(20+lBarp50)
+	sc := 1 + 0.045*cBarp
+	sh := 1 + 0.015*cBarp*t
+	rt := -math.Sin(radians(2*dTheta)) * rc
+
+	lTerm := dLp / sl
+	cTerm := dCp / sc
+	hTerm := dHp / sh
+	return float32(math.Sqrt(lTerm*lTerm + cTerm*cTerm + hTerm*hTerm + rt*cTerm*hTerm))
+}
+
+// hueDegrees returns the hue angle in [0, 360)
+func hueDegrees(b float64, a float64) float64 {
+	if a == 0 && b == 0 {
+		return 0
+	}
+	h := math.Atan2(b, a) * 180 / math.Pi
+	if h < 0 {
+		h += 360
+	}
+	return h
+}
+
+func radians(degrees float64) float64 {
+	return degrees * math.Pi / 180
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"image"
+	"math"
+)
+
+// EdgeWeightedMetric compares pixels by their Lab distance like CIE76Metric,
+// but pixels on edges in the target count more than pixels in flat areas, so
+// that shapes are outlined before large regions are filled in.
+type EdgeWeightedMetric struct {
+	edgeWeight         float32
+	maxDiff            float32
+	precalculatedImage [][]*Lab
+	weights            [][]float32
+}
+
+// NewEdgeWeightedMetric returns a new `EdgeWeightedMetric`. Pixels on the
+// strongest edge of the target weigh `edgeWeight + 1` times as much as pixels
+// in flat areas.
+func NewEdgeWeightedMetric(edgeWeight float32) *EdgeWeightedMetric {
+	return &EdgeWeightedMetric{
+		edgeWeight: edgeWeight,
+		maxDiff:    cie76MaxDiff,
+	}
+}
+
+func (metric *EdgeWeightedMetric) Name() string {
+	return MetricEdgeWeighted
+}
+
+func (metric *EdgeWeightedMetric) MaxDiff() float32 {
+	return metric.maxDiff
+}
+
+func (metric *EdgeWeightedMetric) Radius() int {
+	return 0
+}
+
+func (metric *EdgeWeightedMetric) Precalculate(target image.Image) {
+	metric.precalculatedImage = precalculateLabs(target, getLab)
+	size := target.Bounds().Size()
+	if size.X == 0 || size.Y == 0 {
+		return
+	}
+
+	// Sobel operator over the lightness channel
+	lightness := func(x int, y int) float64 {
+		x = minInt(maxInt(x, 0), size.X-1)
+		y = minInt(maxInt(y, 0), size.Y-1)
+		return float64(metric.precalculatedImage[x][y].l)
+	}
+	var maxMagnitude float32
+	metric.weights = make([][]float32, size.X)
+	for x := 0; x < size.X; x++ {
+		metric.weights[x] = make([]float32, size.Y)
+		for y := 0; y < size.Y; y++ {
+			gx := lightness(x+1, y-1) + 2*lightness(x+1, y) + lightness(x+1, y+1) -
+				lightness(x-1, y-1) - 2*lightness(x-1, y) - lightness(x-1, y+1)
+			gy := lightness(x-1, y+1) + 2*lightness(x, y+1) + lightness(x+1, y+1) -
+				lightness(x-1, y-1) - 2*lightness(x, y-1) - lightness(x+1, y-1)
+			magnitude := float32(math.Hypot(gx, gy))
+			metric.weights[x][y] = magnitude
+			if magnitude > maxMagnitude {
+				maxMagnitude = magnitude
+			}
+		}
+	}
+
+	// Normalize weights so a pixel on the strongest edge weighs 1
+	var totalWeight float32
+	for x := 0; x < size.X; x++ {
+		for y := 0; y < size.Y; y++ {
+			var edge float32
+			if maxMagnitude > 0 {
+				edge = metric.weights[x][y] / maxMagnitude
+			}
+			weight := (1 + metric.edgeWeight*edge) / (1 + metric.edgeWeight)
+			metric.weights[x][y] = weight
+			totalWeight += weight
+		}
+	}
+	metric.maxDiff = cie76MaxDiff * totalWeight / float32(size.X*size.Y)
+}
+
+func (metric *EdgeWeightedMetric) Diff(image image.Image, left int, top int, right int, bottom int, diffMap *DiffMap) {
+	cache := map[uint32]*Lab{}
+	for x := left; x < right; x++ {
+		for y := top; y < bottom; y++ {
+			lab2 := cachedLab(cache, image.At(x, y), getLab)
+			diff := colorDistance(metric.precalculatedImage[x][y], lab2)
+			diffMap.SetDiff(x, y, metric.weights[x][y]*diff)
+		}
+	}
+}
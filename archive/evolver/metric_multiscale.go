@@ -0,0 +1,120 @@
+package main
+
+import "image"
+
+// multiScaleRadii are the box blur radii that images are compared at. Radius 0
+// compares individual pixels, larger radii compare the overall color of areas
+// so that fine dithering isn't required to match smooth gradients.
+var multiScaleRadii = []int{0, 2, 6}
+
+// MultiScaleMetric compares images in the Lab color space at several blur
+// levels and averages the results.
+type MultiScaleMetric struct {
+	target *labGrid
+}
+
+// NewMultiScaleMetric returns a new `MultiScaleMetric`
+func NewMultiScaleMetric() *MultiScaleMetric {
+	return &MultiScaleMetric{}
+}
+
+func (metric *MultiScaleMetric) Name() string {
+	return MetricMultiScale
+}
+
+func (metric *MultiScaleMetric) MaxDiff() float32 {
+	return cie76MaxDiff
+}
+
+func (metric *MultiScaleMetric) Radius() int {
+	return multiScaleRadii[len(multiScaleRadii)-1]
+}
+
+func (metric *MultiScaleMetric) Precalculate(target image.Image) {
+	size := target.Bounds().Size()
+	metric.target = newLabGrid(target, 0, 0, size.X, size.Y)
+}
+
+func (metric *MultiScaleMetric) Diff(image image.Image, left int, top int, right int, bottom int, diffMap *DiffMap) {
+	radius := metric.Radius()
+	rendered := newLabGrid(
+		image,
+		maxInt(left-radius, 0),
+		maxInt(top-radius, 0),
+		minInt(right+radius, metric.target.right),
+		minInt(bottom+radius, metric.target.bottom),
+	)
+	for x := left; x < right; x++ {
+		for y := top; y < bottom; y++ {
+			var diff float32
+			for _, radius := range multiScaleRadii {
+				lab1 := metric.target.mean(x, y, radius)
+				lab2 := rendered.mean(x, y, radius)
+				diff += colorDistance(&lab1, &lab2)
+			}
+			diffMap.SetDiff(x, y, diff/float32(len(multiScaleRadii)))
+		}
+	}
+}
+
+// A labGrid holds summed area tables of the Lab colors of a rectangle of an
+// image, so that the mean color of any window can be looked up quickly.
+type labGrid struct {
+	left   int
+	top    int
+	right  int
+	bottom int
+	// Summed area tables with one row and column of padding, indexed by
+	// x*rows+y
+	sumL []float64
+	sumA []float64
+	sumB []float64
+}
+
+func newLabGrid(image image.Image, left int, top int, right int, bottom int) *labGrid {
+	grid := &labGrid{
+		left:   left,
+		top:    top,
+		right:  right,
+		bottom: bottom,
+	}
+	columns := right - left + 1
+	rows := bottom - top + 1
+	grid.sumL = make([]float64, columns*rows)
+	grid.sumA = make([]float64, columns*rows)
+	grid.sumB = make([]float64, columns*rows)
+	cache := map[uint32]*Lab{}
+	for x := 1; x < columns; x++ {
+		for y := 1; y < rows; y++ {
+			lab := cachedLab(cache, image.At(left+x-1, top+y-1), getLab)
+			i := x*rows + y
+			up := x*rows + y - 1
+			back := (x-1)*rows + y
+			diagonal := (x-1)*rows + y - 1
+			grid.sumL[i] = float64(lab.l) + grid.sumL[up] + grid.sumL[back] - grid.sumL[diagonal]
+			grid.sumA[i] = float64(lab.a) + grid.sumA[up] + grid.sumA[back] - grid.sumA[diagonal]
+			grid.sumB[i] = float64(lab.b) + grid.sumB[up] + grid.sumB[back] - grid.sumB[diagonal]
+		}
+	}
+	return grid
+}
+
+// mean returns the mean color of the window around (x, y), clipped to the grid.
+// Coordinates are relative to the image.
+func (grid *labGrid) mean(x int, y int, radius int) Lab {
+	left := maxInt(x-radius, grid.left) - grid.left
+	top := maxInt(y-radius, grid.top) - grid.top
+	right := minInt(x+radius+1, grid.right) - grid.left
+	bottom := minInt(y+radius+1, grid.bottom) - grid.top
+	rows := grid.bottom - grid.top + 1
+	count := float64((right - left) * (bottom - top))
+	sum := func(table []float64) float32 {
+		total := table[right*rows+bottom] - table[left*rows+bottom] - table[right*rows+top] + table[left*rows+top]
+		return float32(total / count)
+	}
+	return Lab{
+		l: sum(grid.sumL),
+		a: sum(grid.sumA),
+		b: sum(grid.sumB),
+	}
+}
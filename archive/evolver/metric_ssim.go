@@ -0,0 +1,138 @@
+package main
+
+import (
+	"image"
+	"image/color"
+)
+
+// ssimRadius is the radius of the window that local statistics are gathered over
+const ssimRadius = 3
+
+// Stabilizing constants for luminance in [0, 1]: (0.01)^2 and (0.03)^2
+const ssimC1 = 0.0001
+const ssimC2 = 0.0009
+
+// SSIMMetric compares the structure of local neighborhoods of luminance
+// instead of individual pixel colors. The diff of a pixel is (1 - SSIM) / 2,
+// so identical neighborhoods score 0 and inverted ones score 1.
+type SSIMMetric struct {
+	width    int
+	height   int
+	luma     [][]float32
+	mean     [][]float32
+	variance [][]float32
+}
+
+// NewSSIMMetric returns a new `SSIMMetric`
+func NewSSIMMetric() *SSIMMetric {
+	return &SSIMMetric{}
+}
+
+func (metric *SSIMMetric) Name() string {
+	return MetricSSIM
+}
+
+func (metric *SSIMMetric) MaxDiff() float32 {
+	return 1
+}
+
+func (metric *SSIMMetric) Radius() int {
+	return ssimRadius
+}
+
+func (metric *SSIMMetric) Precalculate(target image.Image) {
+	size := target.Bounds().Size()
+	metric.width = size.X
+	metric.height = size.Y
+	metric.luma = make([][]float32, size.X)
+	for x := 0; x < size.X; x++ {
+		metric.luma[x] = make([]float32, size.Y)
+		for y := 0; y < size.Y; y++ {
+			metric.luma[x][y] = luma(target.At(x, y))
+		}
+	}
+	metric.mean = make([][]float32, size.X)
+	metric.variance = make([][]float32, size.X)
+	for x := 0; x < size.X; x++ {
+		metric.mean[x] = make([]float32, size.Y)
+		metric.variance[x] = make([]float32, size.Y)
+		for y := 0; y < size.Y; y++ {
+			var sum, sumSquares, count float32
+			metric.eachInWindow(x, y, func(wx int, wy int) {
+				value := metric.luma[wx][wy]
+				sum += value
+				sumSquares += value * value
+				count++
+			})
+			mean := sum / count
+			metric.mean[x][y] = mean
+			metric.variance[x][y] = sumSquares/count - mean*mean
+		}
+	}
+}
+
+func (metric *SSIMMetric) Diff(image image.Image, left int, top int, right int, bottom int, diffMap *DiffMap) {
+	// Luminance of the rendered image, including the window around the area
+	windowLeft := maxInt(left-ssimRadius, 0)
+	windowTop := maxInt(top-ssimRadius, 0)
+	windowRight := minInt(right+ssimRadius, metric.width)
+	windowBottom := minInt(bottom+ssimRadius, metric.height)
+	rendered := make([][]float32, windowRight-windowLeft)
+	for x := windowLeft; x < windowRight; x++ {
+		column := make([]float32, windowBottom-windowTop)
+		for y := windowTop; y < windowBottom; y++ {
+			column[y-windowTop] = luma(image.At(x, y))
+		}
+		rendered[x-windowLeft] = column
+	}
+
+	for x := left; x < right; x++ {
+		for y := top; y < bottom; y++ {
+			var sum, sumSquares, sumProducts, count float32
+			metric.eachInWindow(x, y, func(wx int, wy int) {
+				value := rendered[wx-windowLeft][wy-windowTop]
+				sum += value
+				sumSquares += value * value
+				sumProducts += value * metric.luma[wx][wy]
+				count++
+			})
+			targetMean := metric.mean[x][y]
+			mean := sum / count
+			variance := sumSquares/count - mean*mean
+			covariance := sumProducts/count - mean*targetMean
+			ssim := ((2*targetMean*mean + ssimC1) * (2*covariance + ssimC2)) /
+				((targetMean*targetMean + mean*mean + ssimC1) * (metric.variance[x][y] + variance + ssimC2))
+			diffMap.SetDiff(x, y, (1-ssim)/2)
+		}
+	}
+}
+
+// eachInWindow calls fn for every pixel in the window around (x, y), clipped
+// to the image.
+func (metric *SSIMMetric) eachInWindow(x int, y int, fn func(int, int)) {
+	for wx := maxInt(x-ssimRadius, 0); wx < minInt(x+ssimRadius+1, metric.width); wx++ {
+		for wy := maxInt(y-ssimRadius, 0); wy < minInt(y+ssimRadius+1, metric.height); wy++ {
+			fn(wx, wy)
+		}
+	}
+}
+
+// luma returns the perceived brightness of a color in [0, 1]
+func luma(clr color.Color) float32 {
+	r, g, b, _ := clr.RGBA()
+	return (0.299*float32(r) + 0.587*float32(g) + 0.114*float32(b)) / 0xffff
+}
+
+func minInt(a int, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func maxInt(a int, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
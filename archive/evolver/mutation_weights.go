@@ -0,0 +1,146 @@
+package main
+
+import "math/rand"
+
+// Mutation operations that the Mutator chooses between
+const (
+	MutationNone      = iota // The organism wasn't produced by a weighted mutation
+	MutationAppend           // Append a random instruction
+	MutationDuplicate        // Append a mutated duplicate of a random instruction
+	MutationDelete           // Delete a random instruction
+	MutationReplace          // Replace a random instruction with a mutated copy
+	MutationSwap             // Swap two random instructions
+	mutationCount
+)
+
+var mutationNames = [mutationCount]string{"none", "append", "duplicate", "delete", "replace", "swap"}
+
+// MutationWeights chooses mutation operations at random in proportion to their
+// weights. In adaptive mode, each configured weight is scaled by how often the
+// operation produced an improvement over its most recent attempts, so that
+// operations that currently work well are tried more often.
+type MutationWeights struct {
+	base      [mutationCount]float32
+	weights   [mutationCount]float32
+	adaptive  bool
+	window    int
+	floor     float32
+	outcomes  [mutationCount][]bool // Ring buffer of recent outcomes per operation
+	next      [mutationCount]int
+	successes [mutationCount]int
+}
+
+// NewMutationWeights returns a new `MutationWeights` using the weights from the config
+func NewMutationWeights(config *Config) *MutationWeights {
+	weights := new(MutationWeights)
+	weights.base[MutationAppend] = config.AppendMutationWeight
+	weights.base[MutationDuplicate] = config.DuplicateMutationWeight
+	weights.base[MutationDelete] = config.DeleteMutationWeight
+	weights.base[MutationReplace] = config.ReplaceMutationWeight
+	weights.base[MutationSwap] = config.SwapMutationWeight
+	weights.adaptive = config.AdaptiveMutation && config.AdaptiveMutationWindow > 0
+	weights.window = config.AdaptiveMutationWindow
+	weights.floor = config.AdaptiveMutationFloor
+	weights.recalculate()
+	return weights
+}
+
+// Choose picks a mutation operation. Operations that need existing
+// instructions aren't chosen when there are none.
+func (weights *MutationWeights) Choose(hasInstructions bool) int {
+	if !hasInstructions {
+		return MutationAppend
+	}
+	operation := chooseWeighted(weights.weights[:]...)
+	if operation == MutationNone {
+		// All weights are zero
+		return MutationAppend
+	}
+	return operation
+}
+
+// Record remembers whether an organism produced by the operation improved on
+// its parent. It has no effect unless adaptive mode is enabled.
+func (weights *MutationWeights) Record(operation int, improved bool) {
+	if !weights.adaptive || operation <= MutationNone || operation >= mutationCount {
+		return
+	}
+	outcomes := weights.outcomes[operation]
+	if len(outcomes) < weights.window {
+		weights.outcomes[operation] = append(outcomes, improved)
+	} else {
+		i := weights.next[operation]
+		if outcomes[i] {
+			weights.successes[operation]--
+		}
+		outcomes[i] = improved
+		weights.next[operation] = (i + 1) % weights.window
+	}
+	if improved {
+		weights.successes[operation]++
+	}
+	weights.recalculate()
+}
+
+// Shares returns the probability of each operation being chosen, by name
+func (weights *MutationWeights) Shares() map[string]float32 {
+	var total float32
+	for _, weight := range weights.weights {
+		total += weight
+	}
+	shares := map[string]float32{}
+	for operation := MutationNone + 1; operation < mutationCount; operation++ {
+		if total > 0 {
+			shares[mutationNames[operation]] = weights.weights[operation] / total
+		}
+	}
+	return shares
+}
+
+func (weights *MutationWeights) recalculate() {
+	for operation := MutationNone + 1; operation < mutationCount; operation++ {
+		weight := weights.base[operation]
+		if weights.adaptive {
+			// Operations without attempts yet are assumed to succeed, so that
+			// each one gets tried before being judged
+			rate := float32(1)
+			if attempts := len(weights.outcomes[operation]); attempts > 0 {
+				rate = float32(weights.successes[operation]) / float32(attempts)
+			}
+			weight *= rate + weights.floor
+		}
+		weights.weights[operation] = weight
+	}
+}
+
+// chooseWeighted returns the index of one of the weights at random, in
+// proportion to the weights. Negative weights count as zero. If all weights
+// are zero, the first index is returned.
+func chooseWeighted(weights ...float32) int {
+	var total float32
+	for _, weight := range weights {
+		if weight > 0 {
+			total += weight
+		}
+	}
+	if total <= 0 {
+		return 0
+	}
+	value := rand.Float32() * total
+	for i, weight := range weights {
+		if weight <= 0 {
+			continue
+		}
+		if value < weight {
+			return i
+		}
+		value -= weight
+	}
+	// Rounding errors can leave a tiny remainder; use the last positive weight
+	for i := len(weights) - 1; i >= 0; i-- {
+		if weights[i] > 0 {
+			return i
+		}
+	}
+	return 0
+}
@@ -2,24 +2,30 @@ package main
 
 import (
 	"image"
-	"log"
+	"math"
 	"math/rand"
+
+	colorful "github.com/lucasb-eyer/go-colorful"
 )
 
 // A Mutator provides a way to alter organisms in an attempt to improve them.
 type Mutator struct {
+	config                *Config
 	instructionMutatorMap map[string]InstructionMutator
 	instructionMutators   []InstructionMutator
 	focusMap              image.Image
 	maxFocusValue         int
+	weights               *MutationWeights
 }
 
 // NewMutator returns a new Mutator
 // focusMap is an optional arg, if provided the mutator will apply focus
 // to certain areas with higher value.
-func NewMutator(instructionMutators []InstructionMutator, focusMap image.Image) *Mutator {
+func NewMutator(config *Config, instructionMutators []InstructionMutator, focusMap image.Image) *Mutator {
 	mut := new(Mutator)
+	mut.config = config
 	mut.focusMap = focusMap
+	mut.weights = NewMutationWeights(config)
 	if focusMap != nil {
 		// scan for the largest value in the map
 		for x := 0; x < focusMap.Bounds().Size().X; x++ {
@@ -41,36 +47,76 @@ func NewMutator(instructionMutators []InstructionMutator, focusMap image.Image)
 	return mut
 }
 
-// Optimize outputs a stream of delete operations for each instruction,
-// and then closes the stream once all instructions have been output.
-func (mut *Mutator) Optimize(organism *Organism) <-chan PatchOperation {
+// Optimize outputs a stream of candidate simplifications of the organism, and
+// then closes the stream once all instructions have been tried. Each candidate
+// deletes one instruction, or with OptimizationMerge also merges it into the
+// next overlapping instruction of the same type.
+func (mut *Mutator) Optimize(organism *Organism) <-chan []PatchOperation {
 	organism = organism.Clone()
-	stream := make(chan PatchOperation)
+	stream := make(chan []PatchOperation)
 	go func() {
-		total := len(organism.Instructions)
+		// The organism is released before the stream is closed, so that it
+		// is back in the pool once the stream has been drained
+		defer close(stream)
 		defer objectPool.ReturnOrganism(organism)
 		for i, instruction := range organism.Instructions {
-			stream <- PatchOperation{
-				InstructionHash1: instruction.Hash(),
-				OperationType:    PatchOperationDelete,
+			stream <- []PatchOperation{
+				{
+					InstructionHash1: instruction.Hash(),
+					OperationType:    PatchOperationDelete,
+				},
+			}
+			if mut.config.OptimizationMerge {
+				if operations := mut.mergeOperations(instruction, organism.Instructions[i+1:]); operations != nil {
+					stream <- operations
+				}
 			}
-			progress := float64(i) / float64(total) * 100
-			log.Printf("Optimization %.4f%% Completed", progress)
 		}
-		close(stream)
 	}()
 	return stream
 }
 
-// Mutate is the primary function of the mutator
+// mergeOperations returns operations that merge the instruction into the first
+// of the following instructions that has the same type and overlaps it, or nil
+// if there is no such instruction.
+func (mut *Mutator) mergeOperations(instruction Instruction, following []Instruction) []PatchOperation {
+	instructionType, err := GetInstructionType(instruction.Type())
+	if err != nil || instructionType.Merge == nil {
+		return nil
+	}
+	bounds := instruction.Bounds()
+	for _, other := range following {
+		otherBounds := other.Bounds()
+		if other.Type() != instruction.Type() || !bounds.Intersects(&otherBounds) {
+			continue
+		}
+		merged := instructionType.Merge(instruction, other)
+		defer objectPool.ReturnInstruction(merged)
+		return []PatchOperation{
+			{
+				InstructionHash1: instruction.Hash(),
+				OperationType:    PatchOperationDelete,
+			},
+			{
+				InstructionHash1: other.Hash(),
+				InstructionData:  merged.Save(),
+				InstructionType:  merged.Type(),
+				OperationType:    PatchOperationReplace,
+			},
+		}
+	}
+	return nil
+}
+
+// Mutate is the primary function of the mutator. The operation is chosen
+// according to the mutation weights, and recorded on the organism so that its
+// outcome can be reported with RecordOutcome. AffectedAreas is set to the
+// areas affected by this operation only.
 func (mut *Mutator) Mutate(organism *Organism) PatchOperation {
-	// TODO: use configurable weights to skew randomness towards different actions
-	// this will allow for auto-tuning later on
-	// 0 - append random item
-	// 1 - append duplicate of random item, mutated
-	// 2 - delete random item
-	// 3 - mutate random item
-	// 4 - swap random items
+	if rand.Float32() < mut.config.BackgroundMutationRate {
+		return mut.mutateBackground(organism)
+	}
+	var mutation int
 	var operation PatchOperation
 	accepted := false
 	var focusThreshold int
@@ -80,8 +126,9 @@ func (mut *Mutator) Mutate(organism *Organism) PatchOperation {
 
 	for !accepted {
 		organism.AffectedAreas = organism.AffectedAreas[:0]
-		switch rand.Int31n(5) {
-		case 0:
+		mutation = mut.weights.Choose(len(organism.Instructions) > 0)
+		switch mutation {
+		case MutationAppend:
 			item := mut.RandomInstruction()
 			organism.AffectedAreas = append(organism.AffectedAreas, item.Bounds())
 			operation = PatchOperation{
@@ -90,7 +137,7 @@ func (mut *Mutator) Mutate(organism *Organism) PatchOperation {
 				InstructionType: item.Type(),
 			}
 			objectPool.ReturnInstruction(item)
-		case 1:
+		case MutationDuplicate:
 			item := mut.selectRandomInstruction(organism.Instructions)
 			item = item.Clone()
 			instructionMut := mut.instructionMutatorMap[item.Type()]
@@ -101,14 +148,14 @@ func (mut *Mutator) Mutate(organism *Organism) PatchOperation {
 				InstructionData: item.Save(),
 				InstructionType: item.Type(),
 			}
-		case 2:
+		case MutationDelete:
 			item := mut.selectRandomInstruction(organism.Instructions)
 			organism.AffectedAreas = append(organism.AffectedAreas, item.Bounds())
 			operation = PatchOperation{
 				OperationType:    PatchOperationDelete,
 				InstructionHash1: item.Hash(),
 			}
-		case 3:
+		case MutationReplace:
 			item := mut.selectRandomInstruction(organism.Instructions)
 			hash := item.Hash()
 			item = item.Clone()
@@ -122,7 +169,7 @@ func (mut *Mutator) Mutate(organism *Organism) PatchOperation {
 				InstructionData:  item.Save(),
 				InstructionType:  item.Type(),
 			}
-		case 4:
+		case MutationSwap:
 			i := rand.Int31n(int32(len(organism.Instructions)))
 			j := rand.Int31n(int32(len(organism.Instructions)))
 			item1 := organism.Instructions[i]
@@ -152,9 +199,56 @@ func (mut *Mutator) Mutate(organism *Organism) PatchOperation {
 		}
 	}
 	operation.Apply(organism)
+	organism.mutations = append(organism.mutations, mutation)
 	return operation
 }
 
+// RecordOutcome reports whether an organism produced by Mutate improved on
+// its parent, for adaptive mutation weights. Every operation that was applied
+// to the organism shares the outcome.
+func (mut *Mutator) RecordOutcome(organism *Organism, improved bool) {
+	for _, mutation := range organism.mutations {
+		mut.weights.Record(mutation, improved)
+	}
+}
+
+// MutationShares returns the current probability of each mutation operation
+func (mut *Mutator) MutationShares() map[string]float32 {
+	return mut.weights.Shares()
+}
+
+// mutateBackground changes the hue, saturation or lightness of the organism's
+// background. Every pixel is affected, so focus maps don't apply.
+func (mut *Mutator) mutateBackground(organism *Organism) PatchOperation {
+	background, _ := colorful.MakeColor(organism.Background)
+	hue, sat, lightness := background.Hsl()
+	switch rand.Int31n(3) {
+	case 0:
+		hue = math.Mod(hue+mut.mutationAmount(mut.config.MinHueMutation, mut.config.MaxHueMutation)+360, 360)
+	case 1:
+		sat = math.Max(0, math.Min(1, sat+mut.mutationAmount(mut.config.MinSaturationMutation, mut.config.MaxSaturationMutation)))
+	default:
+		lightness = math.Max(0, math.Min(1, lightness+mut.mutationAmount(mut.config.MinValueMutation, mut.config.MaxValueMutation)))
+	}
+	organism.AffectedAreas = organism.AffectedAreas[:0]
+	organism.AffectedAreas = append(organism.AffectedAreas, CanvasRect)
+	operation := PatchOperation{
+		OperationType: PatchOperationBackground,
+		Background:    SaveColorHex(colorful.Hsl(hue, sat, lightness)),
+	}
+	operation.Apply(organism)
+	return operation
+}
+
+// mutationAmount returns a random amount between min and max in either direction
+func (mut *Mutator) mutationAmount(min float32, max float32) float64 {
+	amount := float64(rand.Float32()*(max-min) + min)
+	if rand.Intn(2) == 0 {
+		return -amount
+	}
+	return amount
+}
+
 // RandomInstruction returns a new random Instruction
 func (mut *Mutator) RandomInstruction() Instruction {
 	i := int(rand.Intn(len(mut.instructionMutators)))
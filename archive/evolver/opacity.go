@@ -0,0 +1,27 @@
+package main
+
+import (
+	"math"
+	"math/rand"
+)
+
+// legacyOpacity is the opacity of instructions saved before opacity was
+// supported, which are opaque
+const legacyOpacity float32 = 1
+
+// clampOpacity limits an opacity to [0, 1], so that saved values out of range
+// can't overflow the alpha of a color
+func clampOpacity(opacity float32) float32 {
+	return float32(math.Max(0, math.Min(1, float64(opacity))))
+}
+
+// mutateOpacity makes an instruction more or less transparent. Unlike the
+// other color components, opacity doesn't wrap around so that opaque
+// instructions don't suddenly become invisible.
+func mutateOpacity(opacity *float32, config *Config) {
+	amt := rand.Float32()*(config.MaxAlphaMutation-config.MinAlphaMutation) + config.MinAlphaMutation
+	if rand.Intn(2) == 0 {
+		amt = -amt
+	}
+	*opacity = clampOpacity(*opacity + amt)
+}
@@ -4,24 +4,34 @@ import (
 	"bytes"
 	"crypto/md5"
 	"fmt"
+	"image/color"
+	"log"
 )
 
+// organismBackground is saved in place of an instruction type to record the
+// background color of an organism
+const organismBackground = "background"
+
 // An Organism is an attempt at matching an image with
 // a set of painting instructions
 type Organism struct {
 	Instructions  []Instruction
-	Diff          float32
+	Background    color.RGBA // The canvas is filled with this color before instructions are drawn
+	Diff          float32    // Fitness used for selection: RawDiff plus the complexity penalty
+	RawDiff       float32    // Difference between the rendered organism and the target
 	hash          string
 	diffMap       *DiffMap
 	Parent        *Organism
 	AffectedAreas []Rect
 	Patch         *Patch
+	mutations     []int // The mutation operations that produced the organism from its parent
 }
 
 // Hash returns a (probably) unique hash that represents this organism
 func (organism *Organism) Hash() string {
 	if organism.hash == "" {
 		hasher := md5.New()
+		hasher.Write([]byte(SaveColorHex(organism.Background)))
 		for _, instruction := range organism.Instructions {
 			hasher.Write([]byte(instruction.Hash()))
 		}
@@ -32,10 +42,9 @@ func (organism *Organism) Hash() string {
 
 func (organism *Organism) Save() []byte {
 	buf := &bytes.Buffer{}
-	for i, instruction := range organism.Instructions {
-		if i > 0 {
-			buf.Write([]byte("\t"))
-		}
+	organism.saveBackground(buf)
+	for _, instruction := range organism.Instructions {
+		buf.Write([]byte("\t"))
 		buf.Write([]byte(instruction.Type()))
 		buf.Write([]byte("|"))
 		buf.Write(instruction.Save())
@@ -47,10 +56,9 @@ func (organism *Organism) Save() []byte {
 // SaveV2 uses a newline delimiter between instructions
 func (organism *Organism) SaveV2() []byte {
 	buf := &bytes.Buffer{}
-	for i, instruction := range organism.Instructions {
-		if i > 0 {
-			buf.Write([]byte("\n"))
-		}
+	organism.saveBackground(buf)
+	for _, instruction := range organism.Instructions {
+		buf.Write([]byte("\n"))
 		buf.Write([]byte(instruction.Type()))
 		buf.Write([]byte("|"))
 		buf.Write(instruction.Save())
@@ -59,12 +67,29 @@ func (organism *Organism) SaveV2() []byte {
 	return buf.Bytes()
 }
 
+// saveBackground writes the background color in the same form as an instruction
+func (organism *Organism) saveBackground(buf *bytes.Buffer) {
+	buf.Write([]byte(organismBackground))
+	buf.Write([]byte("|"))
+	buf.Write([]byte(SaveColorHex(organism.Background)))
+}
+
 func (organism *Organism) Load(data []byte) {
+	// Organisms saved before backgrounds were introduced are drawn on black
+	organism.Background = color.RGBA{A: 255}
 	instructionData := bytes.Split(data, []byte("\t"))
 	for _, instructionDataItem := range instructionData {
 		parts := bytes.Split(instructionDataItem, []byte("|"))
 		instructionType := string(parts[0])
-		instruction := LoadInstruction(instructionType, parts[1])
+		if instructionType == organismBackground {
+			organism.Background = *LoadColorHex(string(parts[1]))
+			continue
+		}
+		instruction, err := LoadInstruction(instructionType, parts[1])
+		if err != nil {
+			log.Printf("Error loading instruction: %v", err.Error())
+			continue
+		}
 		organism.Instructions = append(organism.Instructions, instruction)
 	}
 }
@@ -74,6 +99,8 @@ func (organism *Organism) Clone() *Organism {
 	clone := objectPool.BorrowOrganism()
 	clone.AffectedAreas = append(clone.AffectedAreas, organism.AffectedAreas...)
 	clone.Diff = organism.Diff
+	clone.RawDiff = organism.RawDiff
+	clone.Background = organism.Background
 	clone.Parent = organism
 	for _, instruction := range organism.Instructions {
 		clone.Instructions = append(clone.Instructions, instruction.Clone())
@@ -2,6 +2,7 @@ package main
 
 import (
 	"context"
+	"image/color"
 
 	pool "github.com/jolestar/go-commons-pool"
 )
@@ -19,6 +20,8 @@ func NewOrganismFactory() *OrganismFactory {
 func (f *OrganismFactory) MakeObject(ctx context.Context) (*pool.PooledObject, error) {
 	return pool.NewPooledObject(&Organism{
 		Diff:          -1,
+		RawDiff:       -1,
+		Background:    color.RGBA{A: 255},
 		Instructions:  []Instruction{},
 		AffectedAreas: []Rect{},
 	}), nil
@@ -54,9 +57,12 @@ func (f *OrganismFactory) PassivateObject(ctx context.Context, object *pool.Pool
 	}
 	organism.AffectedAreas = organism.AffectedAreas[:0]
 	organism.Diff = -1
+	organism.RawDiff = -1
+	organism.Background = color.RGBA{A: 255}
 	organism.hash = ""
 	organism.Parent = nil
 	organism.Patch = nil
+	organism.mutations = organism.mutations[:0]
 	organism.diffMap = nil
 	return nil
 }
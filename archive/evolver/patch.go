@@ -1,5 +1,7 @@
 package main
 
+import "log"
+
 // TODO: change this to V2
 
 const (
@@ -11,6 +13,8 @@ const (
 	PatchOperationReplace = "r"
 	// PatchOperationSwap - swap two items
 	PatchOperationSwap = "s"
+	// PatchOperationBackground - change the background color
+	PatchOperationBackground = "b"
 )
 
 // A PatchOperation represents a single element of a patch.
@@ -19,15 +23,14 @@ type PatchOperation struct {
 	InstructionHash2 string `json:"hash2,omitempty"`
 	InstructionData  []byte `json:"data,omitempty"`
 	InstructionType  string `json:"type,omitempty"`
+	Background       string `json:"background,omitempty"`
 	OperationType    string `json:"op"`
 }
 
 // LoadInstruction will return an `Instruction` that is loaded from
 // the saved instruction data.
-func (operation PatchOperation) LoadInstruction() Instruction {
-	item := objectPool.BorrowInstruction(operation.InstructionType)
-	item.Load(operation.InstructionData)
-	return item
+func (operation PatchOperation) LoadInstruction() (Instruction, error) {
+	return LoadInstruction(operation.InstructionType, operation.InstructionData)
 }
 
 // Apply applies the operation to the organism
@@ -35,7 +38,11 @@ func (operation PatchOperation) Apply(organism *Organism) []Rect {
 	affectedAreas := []Rect{}
 	switch operation.OperationType {
 	case PatchOperationAppend:
-		item := operation.LoadInstruction()
+		item, err := operation.LoadInstruction()
+		if err != nil {
+			log.Printf("Error applying patch operation: %v", err.Error())
+			break
+		}
 		affectedAreas = append(affectedAreas, item.Bounds())
 		organism.Instructions = append(organism.Instructions, item)
 	case PatchOperationDelete:
@@ -49,10 +56,14 @@ func (operation PatchOperation) Apply(organism *Organism) []Rect {
 	case PatchOperationReplace:
 		for idx, item := range organism.Instructions {
 			if item.Hash() == operation.InstructionHash1 {
+				replacement, err := operation.LoadInstruction()
+				if err != nil {
+					log.Printf("Error applying patch operation: %v", err.Error())
+					break
+				}
 				affectedAreas = append(affectedAreas, item.Bounds())
-				item := operation.LoadInstruction()
-				affectedAreas = append(affectedAreas, item.Bounds())
-				organism.Instructions[idx] = item
+				affectedAreas = append(affectedAreas, replacement.Bounds())
+				organism.Instructions[idx] = replacement
 				break
 			}
 		}
@@ -75,6 +86,9 @@ func (operation PatchOperation) Apply(organism *Organism) []Rect {
 			organism.Instructions[idx1], organism.Instructions[idx2] =
 				organism.Instructions[idx2], organism.Instructions[idx1]
 		}
+	case PatchOperationBackground:
+		organism.Background = *LoadColorHex(operation.Background)
+		affectedAreas = append(affectedAreas, CanvasRect)
 	}
 	return affectedAreas
 }
@@ -0,0 +1,104 @@
+package main
+
+// DiffOrganisms returns a patch that transforms one organism into another.
+// Instructions that are in the same order in both organisms are kept, and
+// instructions that differ in place are replaced. From the first point where
+// the order differs, the remaining instructions are deleted and appended
+// again. If from is nil, the patch starts from an empty organism.
+func DiffOrganisms(from *Organism, to *Organism) *Patch {
+	patch := objectPool.BorrowPatch()
+	patch.Baseline = "<none>"
+	patch.Target = to.Hash()
+	if from != nil {
+		patch.Baseline = from.Hash()
+		if from.Background != to.Background {
+			patch.Operations = append(patch.Operations, backgroundOperation(to))
+		}
+	} else {
+		patch.Operations = append(patch.Operations, backgroundOperation(to))
+	}
+	fromInstructions := []Instruction{}
+	if from != nil {
+		fromInstructions = from.Instructions
+	}
+	fromHashes := map[string]bool{}
+	for _, instruction := range fromInstructions {
+		fromHashes[instruction.Hash()] = true
+	}
+	toHashes := map[string]bool{}
+	for _, instruction := range to.Instructions {
+		toHashes[instruction.Hash()] = true
+	}
+
+	i, j := 0, 0
+	for i < len(to.Instructions) && j < len(fromInstructions) {
+		toHash := to.Instructions[i].Hash()
+		fromHash := fromInstructions[j].Hash()
+		if toHash == fromHash {
+			i++
+			j++
+		} else if !toHashes[fromHash] && !fromHashes[toHash] {
+			patch.Operations = append(patch.Operations, PatchOperation{
+				OperationType:    PatchOperationReplace,
+				InstructionHash1: fromHash,
+				InstructionType:  to.Instructions[i].Type(),
+				InstructionData:  to.Instructions[i].Save(),
+			})
+			i++
+			j++
+		} else if !toHashes[fromHash] {
+			patch.Operations = append(patch.Operations, PatchOperation{
+				OperationType:    PatchOperationDelete,
+				InstructionHash1: fromHash,
+			})
+			j++
+		} else {
+			break
+		}
+	}
+	for _, instruction := range fromInstructions[j:] {
+		patch.Operations = append(patch.Operations, PatchOperation{
+			OperationType:    PatchOperationDelete,
+			InstructionHash1: instruction.Hash(),
+		})
+	}
+	for _, instruction := range to.Instructions[i:] {
+		patch.Operations = append(patch.Operations, PatchOperation{
+			OperationType:   PatchOperationAppend,
+			InstructionType: instruction.Type(),
+			InstructionData: instruction.Save(),
+		})
+	}
+	return patch
+}
+
+func backgroundOperation(organism *Organism) PatchOperation {
+	return PatchOperation{
+		OperationType: PatchOperationBackground,
+		Background:    SaveColorHex(organism.Background),
+	}
+}
+
+// ReplacementPatch returns a patch that deletes every instruction of one
+// organism and appends the instructions of another, for when DiffOrganisms
+// can't express the change, for example because of duplicate instructions
+func ReplacementPatch(from *Organism, to *Organism) *Patch {
+	patch := objectPool.BorrowPatch()
+	patch.Baseline = from.Hash()
+	patch.Target = to.Hash()
+	patch.Operations = append(patch.Operations, backgroundOperation(to))
+	for _, instruction := range from.Instructions {
+		patch.Operations = append(patch.Operations, PatchOperation{
+			OperationType:    PatchOperationDelete,
+			InstructionHash1: instruction.Hash(),
+		})
+	}
+	for _, instruction := range to.Instructions {
+		patch.Operations = append(patch.Operations, PatchOperation{
+			OperationType:   PatchOperationAppend,
+			InstructionType: instruction.Type(),
+			InstructionData: instruction.Save(),
+		})
+	}
+	return patch
+}
@@ -0,0 +1,407 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// A JournalEntry records how the top organism changed into a new one
+type JournalEntry struct {
+	Patch     *Patch
+	Iteration int
+	Diff      float32 // Fitness of the target organism, including the complexity penalty
+	Timestamp time.Time
+}
+
+// journalPosition locates an entry in the journal file
+type journalPosition struct {
+	offset   int64
+	length   int
+	baseline string
+	depth    int // Number of entries in the lineage up to and including this one
+}
+
+// A PatchJournal is an append-only file of JournalEntries, one json object
+// per line. Entries are indexed by their target hash, so that patches can be
+// combined from any organism in the journal to a later one, and the lineage
+// of an organism can be replayed. It is safe for concurrent use.
+type PatchJournal struct {
+	mutex    sync.Mutex
+	filename string
+	file     *os.File
+	size     int64
+	index    map[string]journalPosition
+	roots    map[string]bool // Baselines of entries that don't start from another entry
+	latest   string          // target of the last entry
+	// number of entries appended since the journal was last compacted
+	appended int
+}
+
+// OpenPatchJournal opens a journal file, creating it if it doesn't exist. An
+// incomplete entry at the end of the file, left by a crash, is discarded.
+func OpenPatchJournal(filename string) (*PatchJournal, error) {
+	journal := new(PatchJournal)
+	journal.filename = filename
+	err := journal.open()
+	if err != nil {
+		return nil, err
+	}
+	return journal, nil
+}
+
+func (journal *PatchJournal) open() error {
+	file, err := os.OpenFile(journal.filename, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	journal.file = file
+	journal.index = map[string]journalPosition{}
+	journal.roots = map[string]bool{}
+	journal.latest = ""
+	journal.size = 0
+	reader := bufio.NewReader(file)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err == io.EOF && len(line) == 0 {
+			break
+		}
+		entry := &JournalEntry{}
+		if err != nil || json.Unmarshal(line, entry) != nil || entry.Patch == nil {
+			log.Printf("Discarding incomplete entry at the end of %v", journal.filename)
+			break
+		}
+		journal.record(entry.Patch, len(line))
+	}
+	err = file.Truncate(journal.size)
+	if err != nil {
+		file.Close()
+		return err
+	}
+	_, err = file.Seek(journal.size, io.SeekStart)
+	if err != nil {
+		file.Close()
+		return err
+	}
+	return nil
+}
+
+// record indexes an entry of length bytes at the end of the file
+func (journal *PatchJournal) record(patch *Patch, length int) {
+	position := journalPosition{
+		offset:   journal.size,
+		length:   length,
+		baseline: patch.Baseline,
+		depth:    1,
+	}
+	if previous, has := journal.index[patch.Baseline]; has {
+		position.depth = previous.depth + 1
+	} else {
+		journal.roots[patch.Baseline] = true
+	}
+	journal.index[patch.Target] = position
+	journal.latest = patch.Target
+	journal.size += int64(length)
+}
+
+// Close closes the journal file
+func (journal *PatchJournal) Close() error {
+	journal.mutex.Lock()
+	defer journal.mutex.Unlock()
+	return journal.file.Close()
+}
+
+// Len returns the number of entries in the journal
+func (journal *PatchJournal) Len() int {
+	journal.mutex.Lock()
+	defer journal.mutex.Unlock()
+	return len(journal.index)
+}
+
+// Latest returns the target hash of the last entry, or an empty string if
+// the journal is empty
+func (journal *PatchJournal) Latest() string {
+	journal.mutex.Lock()
+	defer journal.mutex.Unlock()
+	return journal.latest
+}
+
+// Has returns true if the journal has an entry for the target organism
+func (journal *PatchJournal) Has(target string) bool {
+	journal.mutex.Lock()
+	defer journal.mutex.Unlock()
+	_, has := journal.index[target]
+	return has
+}
+
+// Append adds an entry to the end of the journal
+func (journal *PatchJournal) Append(entry *JournalEntry) error {
+	journal.mutex.Lock()
+	defer journal.mutex.Unlock()
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+	_, err = journal.file.Write(data)
+	if err != nil {
+		return err
+	}
+	journal.record(entry.Patch, len(data))
+	journal.appended++
+	return nil
+}
+
+// Get reads the entry for the target organism
+func (journal *PatchJournal) Get(target string) (*JournalEntry, error) {
+	journal.mutex.Lock()
+	defer journal.mutex.Unlock()
+	return journal.get(target)
+}
+
+func (journal *PatchJournal) get(target string) (*JournalEntry, error) {
+	position, has := journal.index[target]
+	if !has {
+		return nil, fmt.Errorf("No journal entry for %v", target)
+	}
+	data := make([]byte, position.length)
+	_, err := journal.file.ReadAt(data, position.offset)
+	if err != nil {
+		return nil, err
+	}
+	entry := &JournalEntry{Patch: objectPool.BorrowPatch()}
+	err = json.Unmarshal(data, entry)
+	if err != nil {
+		objectPool.ReturnPatch(entry.Patch)
+		return nil, err
+	}
+	return entry, nil
+}
+
+// Lineage returns the targets of the entries leading up to the target
+// organism, oldest first, ending with the target. The first entry starts
+// from an empty organism, or from an organism that isn't in the journal.
+func (journal *PatchJournal) Lineage(target string) []string {
+	journal.mutex.Lock()
+	defer journal.mutex.Unlock()
+	return journal.lineage(target)
+}
+
+func (journal *PatchJournal) lineage(target string) []string {
+	lineage := []string{}
+	visited := map[string]bool{}
+	for {
+		position, has := journal.index[target]
+		if !has || visited[target] {
+			break
+		}
+		visited[target] = true
+		lineage = append(lineage, target)
+		target = position.baseline
+	}
+	for i, j := 0, len(lineage)-1; i < j; i, j = i+1, j-1 {
+		lineage[i], lineage[j] = lineage[j], lineage[i]
+	}
+	return lineage
+}
+
+// Origin returns the baseline of the first entry in the lineage of the target
+// organism, which is "<none>" if it starts from an empty organism
+func (journal *PatchJournal) Origin(target string) string {
+	journal.mutex.Lock()
+	defer journal.mutex.Unlock()
+	lineage := journal.lineage(target)
+	if len(lineage) == 0 {
+		return ""
+	}
+	return journal.index[lineage[0]].baseline
+}
+
+// GetPatch combines the entries from the baseline organism to the target
+// organism into a single patch. It returns nil if the target doesn't descend
+// from the baseline. Only the entries between the two are visited.
+func (journal *PatchJournal) GetPatch(baseline string, target string) *Patch {
+	journal.mutex.Lock()
+	defer journal.mutex.Unlock()
+	position, has := journal.index[target]
+	if !has {
+		return nil
+	}
+	// The depth of the baseline tells how far back from the target it is
+	depth := 0
+	if previous, has := journal.index[baseline]; has {
+		depth = previous.depth
+	} else if !journal.roots[baseline] {
+		return nil
+	}
+	if depth >= position.depth {
+		return nil
+	}
+	hashes := make([]string, position.depth-depth)
+	hash := target
+	for i := len(hashes) - 1; i >= 0; i-- {
+		hashes[i] = hash
+		hash = position.baseline
+		position = journal.index[hash]
+	}
+	if hash != baseline {
+		return nil
+	}
+	patch := objectPool.BorrowPatch()
+	patch.Baseline = baseline
+	patch.Target = target
+	for _, hash := range hashes {
+		entry, err := journal.get(hash)
+		if err != nil {
+			log.Printf("Error reading journal entry %v: %v", hash, err.Error())
+			objectPool.ReturnPatch(patch)
+			return nil
+		}
+		patch.Operations = append(patch.Operations, entry.Patch.Operations...)
+		objectPool.ReturnPatch(entry.Patch)
+	}
+	return patch
+}
+
+// Replay reconstructs the organisms leading up to the target organism by
+// applying the entries of its lineage to start, which is updated in place.
+// If start is nil, the lineage must begin with an empty organism. Otherwise
+// replay begins after the entry for start, or with the first entry if that
+// is based on start. visit is called with each entry and the organism it
+// produced, and can stop the replay by returning false; it must clone the
+// organism to keep it.
+func (journal *PatchJournal) Replay(target string, start *Organism, visit func(entry *JournalEntry, organism *Organism) bool) error {
+	journal.mutex.Lock()
+	defer journal.mutex.Unlock()
+	lineage := journal.lineage(target)
+	if len(lineage) == 0 {
+		return fmt.Errorf("No journal entry for %v", target)
+	}
+	baseline := "<none>"
+	if start != nil {
+		baseline = start.Hash()
+	}
+	first := -1
+	for i, hash := range lineage {
+		if hash == baseline {
+			first = i + 1
+			break
+		}
+	}
+	if first < 0 && journal.index[lineage[0]].baseline == baseline {
+		first = 0
+	}
+	if first < 0 {
+		return fmt.Errorf("The lineage of %v doesn't include %v", target, baseline)
+	}
+	organism := start
+	if organism == nil {
+		organism = objectPool.BorrowOrganism()
+		defer objectPool.ReturnOrganism(organism)
+	}
+	for _, hash := range lineage[first:] {
+		entry, err := journal.get(hash)
+		if err != nil {
+			return err
+		}
+		organism.hash = ""
+		for _, operation := range entry.Patch.Operations {
+			operation.Apply(organism)
+		}
+		objectPool.ReturnPatch(entry.Patch)
+		if organism.Hash() != hash {
+			return fmt.Errorf("Replaying %v produced %v", hash, organism.Hash())
+		}
+		entry.Patch = nil
+		if !visit(entry, organism) {
+			break
+		}
+	}
+	return nil
+}
+
+// Organism reconstructs the target organism from the start of its lineage,
+// which must begin with an empty organism
+func (journal *PatchJournal) Organism(target string) (*Organism, error) {
+	var organism *Organism
+	err := journal.Replay(target, nil, func(entry *JournalEntry, replayed *Organism) bool {
+		if replayed.Hash() == target {
+			organism = replayed.Clone()
+		}
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	return organism, nil
+}
+
+// CompactionDue returns true if at least the specified number of entries
+// were appended since the journal was last compacted
+func (journal *PatchJournal) CompactionDue(frequency int) bool {
+	journal.mutex.Lock()
+	defer journal.mutex.Unlock()
+	return frequency > 0 && journal.appended >= frequency
+}
+
+// Compact rewrites the journal with only the lineage of the target organism,
+// dropping branches that were abandoned, for example after falling back to
+// an older checkpoint. The file is replaced atomically. Nothing is dropped if
+// the target isn't in the journal.
+func (journal *PatchJournal) Compact(target string) error {
+	journal.mutex.Lock()
+	defer journal.mutex.Unlock()
+	journal.appended = 0
+	lineage := journal.lineage(target)
+	if len(lineage) == 0 || len(lineage) == len(journal.index) {
+		return nil
+	}
+	tempFilename := journal.filename + ".tmp"
+	out, err := os.Create(tempFilename)
+	if err != nil {
+		return err
+	}
+	writer := bufio.NewWriter(out)
+	for _, hash := range lineage {
+		position := journal.index[hash]
+		data := make([]byte, position.length)
+		_, err = journal.file.ReadAt(data, position.offset)
+		if err == nil {
+			_, err = writer.Write(data)
+		}
+		if err != nil {
+			out.Close()
+			os.Remove(tempFilename)
+			return err
+		}
+	}
+	err = writer.Flush()
+	if err == nil {
+		err = out.Sync()
+	}
+	closeErr := out.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tempFilename)
+		return err
+	}
+	log.Printf("Compacting patch journal from %v to %v entries", len(journal.index), len(lineage))
+	journal.file.Close()
+	err = os.Rename(tempFilename, journal.filename)
+	if err != nil {
+		// Keep using the old file
+		os.Remove(tempFilename)
+	}
+	openErr := journal.open()
+	if err == nil {
+		err = openErr
+	}
+	return err
+}
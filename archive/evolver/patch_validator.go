@@ -0,0 +1,185 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+
+	colorful "github.com/lucasb-eyer/go-colorful"
+)
+
+const (
+	// PatchErrorMalformed - the patch isn't valid json, or is missing its hashes
+	PatchErrorMalformed = "malformed"
+	// PatchErrorUnknownOperation - an operation has an unknown type
+	PatchErrorUnknownOperation = "unknown_operation"
+	// PatchErrorUnknownInstruction - an operation has an unknown instruction type
+	PatchErrorUnknownInstruction = "unknown_instruction"
+	// PatchErrorInvalidData - instruction data, hashes or colors can't be parsed
+	PatchErrorInvalidData = "invalid_data"
+	// PatchErrorOutOfCanvas - an instruction lies outside of the canvas
+	PatchErrorOutOfCanvas = "out_of_canvas"
+	// PatchErrorTargetMismatch - applying the patch to its baseline doesn't produce its target
+	PatchErrorTargetMismatch = "target_mismatch"
+	// PatchErrorUnknownBaseline - the server doesn't know the baseline, so the patch can't be verified
+	PatchErrorUnknownBaseline = "unknown_baseline"
+)
+
+// A PatchError describes why a submitted patch was rejected. It is sent back
+// to the worker as json.
+type PatchError struct {
+	Status    int    `json:"-"`
+	Code      string // One of the PatchError constants
+	Message   string
+	Operation int // Index of the offending operation, or -1 if the whole patch is at fault
+}
+
+func (err *PatchError) Error() string {
+	if err.Operation < 0 {
+		return fmt.Sprintf("%v: %v", err.Code, err.Message)
+	}
+	return fmt.Sprintf("%v: operation %v: %v", err.Code, err.Operation, err.Message)
+}
+
+func newPatchError(status int, code string, operation int, format string, args ...interface{}) *PatchError {
+	return &PatchError{
+		Status:    status,
+		Code:      code,
+		Message:   fmt.Sprintf(format, args...),
+		Operation: operation,
+	}
+}
+
+// savedInstructionColor holds the persisted fields that all instruction
+// types share, so that they can be checked without knowing the type.
+type savedInstructionColor struct {
+	SavedColor *SavedColor
+	HexColor   string
+	Opacity    *float32
+}
+
+// A PatchValidator checks patches submitted by workers before they are
+// applied, so that bad data can't crash or corrupt the server.
+type PatchValidator struct {
+	width  float32
+	height float32
+}
+
+// NewPatchValidator returns a new PatchValidator for a canvas of the given size
+func NewPatchValidator(width int, height int) *PatchValidator {
+	validator := new(PatchValidator)
+	validator.width = float32(width)
+	validator.height = float32(height)
+	return validator
+}
+
+// Validate returns the first problem found with a patch, or nil if it can be
+// applied. baseline is the organism the patch was made from, or nil if it
+// isn't known, in which case the worker has to catch up with the server. The
+// patch must transform the baseline into the target.
+func (validator *PatchValidator) Validate(patch *Patch, baseline *Organism) *PatchError {
+	if patch.Baseline == "" || patch.Target == "" {
+		return newPatchError(http.StatusBadRequest, PatchErrorMalformed, -1, "Baseline and target hashes are required")
+	}
+	for i, operation := range patch.Operations {
+		err := validator.validateOperation(i, operation)
+		if err != nil {
+			return err
+		}
+	}
+	if baseline == nil {
+		return newPatchError(http.StatusConflict, PatchErrorUnknownBaseline, -1, "Baseline %v is unknown", patch.Baseline)
+	}
+	organism := baseline.Clone()
+	defer objectPool.ReturnOrganism(organism)
+	organism.hash = ""
+	for _, operation := range patch.Operations {
+		operation.Apply(organism)
+	}
+	if organism.Hash() != patch.Target {
+		return newPatchError(http.StatusConflict, PatchErrorTargetMismatch, -1, "Applying the patch to %v produces %v, not %v", patch.Baseline, organism.Hash(), patch.Target)
+	}
+	return nil
+}
+
+func (validator *PatchValidator) validateOperation(i int, operation PatchOperation) *PatchError {
+	switch operation.OperationType {
+	case PatchOperationAppend, PatchOperationReplace:
+		if operation.OperationType == PatchOperationReplace && operation.InstructionHash1 == "" {
+			return newPatchError(http.StatusBadRequest, PatchErrorInvalidData, i, "Missing instruction hash")
+		}
+		return validator.validateInstruction(i, operation.InstructionType, operation.InstructionData)
+	case PatchOperationDelete:
+		if operation.InstructionHash1 == "" {
+			return newPatchError(http.StatusBadRequest, PatchErrorInvalidData, i, "Missing instruction hash")
+		}
+	case PatchOperationSwap:
+		if operation.InstructionHash1 == "" || operation.InstructionHash2 == "" {
+			return newPatchError(http.StatusBadRequest, PatchErrorInvalidData, i, "Missing instruction hash")
+		}
+	case PatchOperationBackground:
+		_, err := colorful.Hex(operation.Background)
+		if err != nil {
+			return newPatchError(http.StatusBadRequest, PatchErrorInvalidData, i, "Invalid background color '%v'", operation.Background)
+		}
+	default:
+		return newPatchError(http.StatusBadRequest, PatchErrorUnknownOperation, i, "Unknown operation type '%v'", operation.OperationType)
+	}
+	return nil
+}
+
+func (validator *PatchValidator) validateInstruction(i int, instructionType string, data []byte) (patchErr *PatchError) {
+	// Instructions assume that they were created by a mutator, for example
+	// that polygons have points
+	defer func() {
+		if r := recover(); r != nil {
+			patchErr = newPatchError(http.StatusBadRequest, PatchErrorInvalidData, i, "Unusable %v data: %v", instructionType, r)
+		}
+	}()
+	registered, err := GetInstructionType(instructionType)
+	if err != nil {
+		return newPatchError(http.StatusBadRequest, PatchErrorUnknownInstruction, i, err.Error())
+	}
+	// Instructions ignore errors while loading, so check that the data
+	// decodes cleanly first
+	saved := savedInstructionColor{}
+	err = json.Unmarshal(data, &saved)
+	if err != nil {
+		return newPatchError(http.StatusBadRequest, PatchErrorInvalidData, i, "Invalid instruction data: %v", err.Error())
+	}
+	if saved.SavedColor == nil {
+		_, err = colorful.Hex(saved.HexColor)
+		if err != nil {
+			return newPatchError(http.StatusBadRequest, PatchErrorInvalidData, i, "Invalid color '%v'", saved.HexColor)
+		}
+	}
+	if saved.Opacity != nil && (*saved.Opacity < 0 || *saved.Opacity > 1) {
+		return newPatchError(http.StatusBadRequest, PatchErrorInvalidData, i, "Opacity %v is out of range", *saved.Opacity)
+	}
+	instruction := objectPool.BorrowInstruction(registered.Name)
+	defer objectPool.ReturnInstruction(instruction)
+	err = json.Unmarshal(data, instruction)
+	if err != nil {
+		return newPatchError(http.StatusBadRequest, PatchErrorInvalidData, i, "Invalid %v data: %v", registered.Name, err.Error())
+	}
+	instruction.Load(data)
+	return validator.validateBounds(i, instruction.Bounds())
+}
+
+// validateBounds accepts instructions that don't reach further than one
+// canvas size beyond its edges. Mutators keep the positions of instructions
+// on the canvas, but shapes near the edges can still end up entirely outside
+// of it.
+func (validator *PatchValidator) validateBounds(i int, bounds Rect) *PatchError {
+	for _, value := range []float32{bounds.Left, bounds.Top, bounds.Right, bounds.Bottom} {
+		if math.IsNaN(float64(value)) || math.IsInf(float64(value), 0) {
+			return newPatchError(http.StatusUnprocessableEntity, PatchErrorOutOfCanvas, i, "Instruction has invalid coordinates")
+		}
+	}
+	margin := float32(math.Max(float64(validator.width), float64(validator.height)))
+	if bounds.Left < -margin || bounds.Top < -margin || bounds.Right > validator.width+margin || bounds.Bottom > validator.height+margin {
+		return newPatchError(http.StatusUnprocessableEntity, PatchErrorOutOfCanvas, i, "Instruction reaches too far outside of the canvas")
+	}
+	return nil
+}
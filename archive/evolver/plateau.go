@@ -0,0 +1,45 @@
+package main
+
+// A PlateauDetector notices when evolution stops making progress, because
+// the best diff improved by less than a fraction of itself over a window of
+// iterations
+type PlateauDetector struct {
+	window    int
+	threshold float32
+	startDiff float32 // Best diff at the start of the current window
+	bestDiff  float32
+	count     int // Iterations recorded in the current window
+}
+
+// NewPlateauDetector returns a new `PlateauDetector`. A plateau is reached
+// when the diff improves by less than threshold times itself in window
+// iterations.
+func NewPlateauDetector(window int, threshold float32) *PlateauDetector {
+	detector := new(PlateauDetector)
+	detector.window = window
+	detector.threshold = threshold
+	detector.startDiff = -1
+	return detector
+}
+
+// Record adds the best diff of an iteration, and returns true if evolution
+// has reached a plateau
+func (detector *PlateauDetector) Record(diff float32) bool {
+	if detector.startDiff < 0 {
+		detector.startDiff = diff
+		detector.bestDiff = diff
+	}
+	if diff < detector.bestDiff {
+		detector.bestDiff = diff
+	}
+	detector.count++
+	if detector.count < detector.window {
+		return false
+	}
+	if detector.startDiff-detector.bestDiff < detector.startDiff*detector.threshold {
+		return true
+	}
+	detector.startDiff = detector.bestDiff
+	detector.count = 0
+	return false
+}
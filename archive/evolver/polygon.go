@@ -6,13 +6,40 @@ import (
 	"fmt"
 	"image/color"
 	"math"
+	"strings"
 
 	"github.com/fogleman/gg"
+	pool "github.com/jolestar/go-commons-pool"
 )
 
 // TypePolygon is the type name for polygons
 const TypePolygon = "polygon"
 
+func init() {
+	RegisterInstructionType(&InstructionType{
+		Name:       TypePolygon,
+		NewFactory: func() pool.PooledObjectFactory { return NewPolygonFactory() },
+		NewMutator: func(config *Config, imageWidth float32, imageHeight float32) InstructionMutator {
+			return NewPolygonMutator(config, imageWidth, imageHeight)
+		},
+		ExportSVG: func(instruction Instruction) string {
+			polygon := instruction.(*Polygon)
+			points := make([]string, 0, len(polygon.Points))
+			for _, point := range polygon.Points {
+				x, y := point.CalculateCoordinates(polygon.X, polygon.Y)
+				points = append(points, fmt.Sprintf("%v,%v", x, y))
+			}
+			return fmt.Sprintf(
+				"<polygon points=\"%v\" fill=\"%v\"%v/>",
+				strings.Join(points, " "), SaveColorHex(polygon.Color),
+				svgOpacityAttribute("fill-opacity", polygon.Opacity))
+		},
+		Merge: func(a Instruction, b Instruction) Instruction {
+			return a.(*Polygon).Merge(b.(*Polygon))
+		},
+	})
+}
+
 // A Polypoint represents one point of a polygon. It includes the distance
 // from the center, and the angle (in radians) around the center that the
 // point occurs.
@@ -58,13 +85,14 @@ type Polygon struct {
 	Color      *color.RGBA `json:"-"`
 	SavedColor *SavedColor `json:",omitempty"`
 	HexColor   string      `json:",omitempty"`
+	Opacity    float32     // 0 is fully transparent, 1 is fully opaque
 	hash       string
 	bounds     Rect // Cache bounds
 }
 
 // Execute draws a polygon at point
 func (polygon *Polygon) Execute(ctx *gg.Context) {
-	ctx.SetColor(polygon.Color)
+	ctx.SetColor(ColorWithOpacity(polygon.Color, polygon.Opacity))
 	// TODO: test this to see if it actually works
 	x, y := polygon.Points[0].CalculateCoordinates(polygon.X, polygon.Y)
 	ctx.MoveTo(float64(x), float64(y))
@@ -82,6 +110,18 @@ func (polygon *Polygon) Scale(factor float32) Instruction {
 	for i, point := range clone.Points {
 		clone.Points[i] = point.Scale(factor)
 	}
+	clone.bounds = Rect{}
+	clone.hash = ""
+	return clone
+}
+
+// Translate returns a copy of the polygon moved by dx, dy
+func (polygon *Polygon) Translate(dx float32, dy float32) Instruction {
+	clone := polygon.Clone().(*Polygon)
+	clone.X += dx
+	clone.Y += dy
+	clone.bounds = Rect{}
+	clone.hash = ""
 	return clone
 }
 
@@ -92,7 +132,9 @@ func (polygon *Polygon) Save() []byte {
 }
 
 func (polygon *Polygon) Load(data []byte) {
+	polygon.Opacity = legacyOpacity
 	json.Unmarshal(data, polygon)
+	polygon.Opacity = clampOpacity(polygon.Opacity)
 	if polygon.SavedColor != nil {
 		polygon.Color = LoadColor(polygon.SavedColor)
 	} else {
@@ -113,6 +155,7 @@ func (polygon *Polygon) Clone() Instruction {
 	newColor := *polygon.Color
 	newPolygon.Color = &newColor
 	newPolygon.HexColor = polygon.HexColor
+	newPolygon.Opacity = polygon.Opacity
 	newPolygon.SavedColor = polygon.SavedColor
 	newPolygon.X = polygon.X
 	newPolygon.Y = polygon.Y
@@ -120,11 +163,27 @@ func (polygon *Polygon) Clone() Instruction {
 	return newPolygon
 }
 
+// Merge returns a new polygon with the outline of this polygon, centered
+// between the two polygons, in their average color
+func (polygon *Polygon) Merge(other *Polygon) *Polygon {
+	merged := polygon.Clone().(*Polygon)
+	merged.X = (polygon.X + other.X) / 2
+	merged.Y = (polygon.Y + other.Y) / 2
+	merged.Color = MixColors(polygon.Color, other.Color)
+	merged.Opacity = (polygon.Opacity + other.Opacity) / 2
+	merged.bounds = Rect{}
+	merged.RecalculateHash()
+	return merged
+}
+
 func (polygon *Polygon) Hash() string {
 	if polygon.hash == "" {
 		buf := objectPool.BorrowByteBuffer()
 		buf.WriteString(SaveColorHex(polygon.Color))
 		buf.WriteString("|")
+		if polygon.Opacity < 1 {
+			buf.WriteString(fmt.Sprintf("%.4f|", polygon.Opacity))
+		}
 		buf.WriteString(fmt.Sprintf("%.4f|%.4f|", polygon.X, polygon.Y))
 		for _, point := range polygon.Points {
 			buf.WriteString(fmt.Sprintf("%.4f|%.4f|", point.Distance, point.Angle))
@@ -44,6 +44,7 @@ func (f *PolygonFactory) PassivateObject(ctx context.Context, object *pool.Poole
 	obj.bounds = Rect{}
 	obj.hash = ""
 	obj.HexColor = ""
+	obj.Opacity = 0
 	obj.Color = nil
 	obj.X = 0
 	obj.Y = 0
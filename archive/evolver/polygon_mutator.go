@@ -30,7 +30,7 @@ func (mut *PolygonMutator) MutateInstruction(instruction Instruction) {
 	// color
 	// coordinates
 	// radius
-	switch rand.Int31n(3) {
+	switch chooseWeighted(mut.config.ColorMutationWeight, mut.config.CoordinateMutationWeight, mut.config.ShapeMutationWeight) {
 	case 0:
 		// color
 		mut.mutateColor(polygon)
@@ -53,11 +53,13 @@ func (mut *PolygonMutator) InstructionType() string {
 // Red, Green, Blue
 
 func (mut *PolygonMutator) mutateColor(polygon *Polygon) {
-	switch rand.Int31n(3) {
+	switch rand.Int31n(4) {
 	case 0:
 		mut.mutateHue(polygon)
 	case 1:
 		mut.mutateSaturation(polygon)
+	case 2:
+		mutateOpacity(&polygon.Opacity, mut.config)
 	default:
 		mut.mutateLightness(polygon)
 	}
@@ -85,26 +87,19 @@ func (mut *PolygonMutator) mutateLightness(polygon *Polygon) {
 // Bigger
 // Smaller
 func (mut *PolygonMutator) mutatePolygonPoints(polygon *Polygon) {
-	// TODO: allow configurable weights here
-	// weight towards mutating existing points (2/3),
-	// then split the probability between adding a point and removing a point 50/50
-	switch rand.Intn(3) {
-	case 0, 1:
+	switch chooseWeighted(mut.config.MovePointMutationWeight, mut.config.RemovePointMutationWeight, mut.config.AddPointMutationWeight) {
+	case 0:
 		// select a random point and mutate it
 		randomPoint := &polygon.Points[rand.Intn(len(polygon.Points))]
 		mut.mutatePoint(randomPoint)
+	case 1:
+		// Remove a random point, but only if the count remains >= min
+		if len(polygon.Points) > mut.config.MinPolygonPoints {
+			polygon.Points = PolypointList(polygon.Points).RemoveAt(rand.Intn(len(polygon.Points)))
+		}
 	default:
-		// Add or remove a point
-		switch rand.Intn(2) {
-		case 0:
-			// Remove a random point, but only if the count remains >= min
-			if len(polygon.Points) > mut.config.MinPolygonPoints {
-				polygon.Points = PolypointList(polygon.Points).RemoveAt(rand.Intn(len(polygon.Points)))
-			}
-		default:
-			if len(polygon.Points) < mut.config.MaxPolygonPoints {
-				polygon.Points = append(polygon.Points, mut.randomPoint())
-			}
+		if len(polygon.Points) < mut.config.MaxPolygonPoints {
+			polygon.Points = append(polygon.Points, mut.randomPoint())
 		}
 	}
 	sort.Sort(PolypointList(polygon.Points))
@@ -158,6 +153,7 @@ func (mut *PolygonMutator) RandomInstruction() Instruction {
 		B: uint8(rand.Int31n(255)),
 		R: uint8(rand.Int31n(255)),
 	}
+	polygon.Opacity = 1
 	for i := 0; i < numPoints; i++ {
 		polygon.Points = append(polygon.Points, mut.randomPoint())
 	}
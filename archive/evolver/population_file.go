@@ -0,0 +1,201 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/md5"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"time"
+)
+
+// PopulationFormatVersion is the current version of the population file format.
+// Version 1 files have no header, just an iteration number on the first line.
+const PopulationFormatVersion = 2
+
+// A PopulationHeader describes the contents of a population file. It is
+// stored as json on the first line of the file.
+type PopulationHeader struct {
+	Version    int
+	Iteration  int
+	Width      int    // Width of the target image in pixels
+	Height     int    // Height of the target image in pixels
+	Target     string // Filename of the target image
+	ConfigHash string
+	Diff       float32 // Fitness of the top organism, including the complexity penalty
+	RawDiff    float32 // Difference between the top organism and the target
+	Timestamp  time.Time
+	Checksum   string `json:",omitempty"` // md5 of the organisms, to detect incomplete files
+}
+
+// HasDimensions returns true if the canvas size is known. Version 1 files
+// don't record it.
+func (header *PopulationHeader) HasDimensions() bool {
+	return header.Width > 0 && header.Height > 0
+}
+
+// A PopulationFile is a header followed by saved organisms, one per line,
+// with the top organism first.
+type PopulationFile struct {
+	Header    PopulationHeader
+	Organisms [][]byte
+}
+
+// NewPopulationFile returns a new `PopulationFile` with a current version header
+func NewPopulationFile(width int, height int, config *Config) *PopulationFile {
+	file := new(PopulationFile)
+	file.Header.Version = PopulationFormatVersion
+	file.Header.Width = width
+	file.Header.Height = height
+	file.Header.ConfigHash = ConfigHash(config)
+	file.Header.Diff = -1
+	file.Header.RawDiff = -1
+	file.Header.Timestamp = time.Now()
+	file.Organisms = [][]byte{}
+	return file
+}
+
+// LoadPopulationFile reads a population file from disk. Files written before
+// the header was introduced are read as version 1.
+func LoadPopulationFile(filename string) (*PopulationFile, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return ReadPopulationFile(file)
+}
+
+// ReadPopulationFile reads a population file
+func ReadPopulationFile(reader io.Reader) (*PopulationFile, error) {
+	scanner := bufio.NewScanner(reader)
+	// 10 MB buffer for organisms, they might be really big. Adjust as needed.
+	buf := make([]byte, 1024*1024*10)
+	scanner.Buffer(buf, len(buf))
+	if !scanner.Scan() {
+		if scanner.Err() != nil {
+			return nil, scanner.Err()
+		}
+		return nil, fmt.Errorf("Population file is empty")
+	}
+	populationFile := &PopulationFile{}
+	headerLine := bytes.TrimSpace(scanner.Bytes())
+	// Headers written before raw diffs were recorded don't have one
+	populationFile.Header.RawDiff = -1
+	if bytes.HasPrefix(headerLine, []byte("{")) {
+		err := json.Unmarshal(headerLine, &populationFile.Header)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid population file header: %v", err.Error())
+		}
+		if populationFile.Header.Version > PopulationFormatVersion {
+			return nil, fmt.Errorf("Unsupported population file version %v", populationFile.Header.Version)
+		}
+	} else {
+		iteration, err := strconv.ParseInt(string(headerLine), 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid population file iteration '%v'", string(headerLine))
+		}
+		populationFile.Header.Version = 1
+		populationFile.Header.Iteration = int(iteration)
+		populationFile.Header.Diff = -1
+	}
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		organism := make([]byte, len(line))
+		copy(organism, line)
+		populationFile.Organisms = append(populationFile.Organisms, organism)
+	}
+	if scanner.Err() != nil {
+		return nil, scanner.Err()
+	}
+	// Files written before checksums were introduced don't have one
+	if populationFile.Header.Checksum != "" && populationFile.Header.Checksum != populationFile.checksum() {
+		return nil, fmt.Errorf("Population file checksum mismatch, the file is incomplete or corrupt")
+	}
+	return populationFile, nil
+}
+
+// Save writes the population file to disk. The file is written next to its
+// destination first and then renamed, so that the previous file remains
+// intact if writing fails.
+func (populationFile *PopulationFile) Save(filename string) error {
+	tempFilename := filename + ".tmp"
+	file, err := os.Create(tempFilename)
+	if err != nil {
+		return err
+	}
+	err = populationFile.Write(file)
+	if err == nil {
+		err = file.Sync()
+	}
+	closeErr := file.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tempFilename)
+		return err
+	}
+	return os.Rename(tempFilename, filename)
+}
+
+// Write writes the population file, always using the current format version
+func (populationFile *PopulationFile) Write(writer io.Writer) error {
+	populationFile.Header.Version = PopulationFormatVersion
+	populationFile.Header.Checksum = populationFile.checksum()
+	header, err := json.Marshal(populationFile.Header)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(writer)
+	w.Write(header)
+	w.WriteString("\n")
+	for _, organism := range populationFile.Organisms {
+		w.Write(bytes.TrimRight(organism, "\n"))
+		w.WriteString("\n")
+	}
+	return w.Flush()
+}
+
+// checksum returns the md5 of the organisms as they are stored in the file
+func (populationFile *PopulationFile) checksum() string {
+	hasher := md5.New()
+	for _, organism := range populationFile.Organisms {
+		hasher.Write(bytes.TrimSpace(organism))
+		hasher.Write([]byte("\n"))
+	}
+	return fmt.Sprintf("%x", hasher.Sum(nil))
+}
+
+// TopOrganism loads the first organism in the file, or returns nil if the
+// file has no organisms.
+func (populationFile *PopulationFile) TopOrganism() *Organism {
+	if len(populationFile.Organisms) == 0 {
+		return nil
+	}
+	organism := &Organism{}
+	organism.Load(populationFile.Organisms[0])
+	return organism
+}
+
+// ConfigHash returns a hash of the configuration, so that population files
+// can record which settings they were evolved with.
+func ConfigHash(config *Config) string {
+	// Server settings don't affect evolution
+	evolution := *config
+	evolution.ListenAddress = ""
+	evolution.ListenPort = 0
+	evolution.TLSCertFile = ""
+	evolution.TLSKeyFile = ""
+	evolution.TLSCAFile = ""
+	evolution.AuthSecret = ""
+	evolution.MaxRequestSize = 0
+	data, _ := json.Marshal(&evolution)
+	return fmt.Sprintf("%x", md5.Sum(data))
+}
@@ -2,7 +2,8 @@ package main
 
 import "fmt"
 
-// FormatProgress formats an average pixel diff as a progress complete percentage.
-func FormatProgress(diff float32) string {
-	return fmt.Sprintf("%.15f%%", 100.0-((diff/maxImageDiff)*100))
+// FormatProgress formats an average pixel diff as a progress complete percentage,
+// relative to the average diff of a completely wrong image.
+func FormatProgress(diff float32, maxDiff float32) string {
+	return fmt.Sprintf("%.15f%%", 100.0-((diff/maxDiff)*100))
 }
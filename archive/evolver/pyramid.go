@@ -0,0 +1,87 @@
+package main
+
+import (
+	"image"
+	"image/draw"
+	"log"
+	"math"
+)
+
+// pyramidMinSize is the smallest width or height of a pyramid level. Smaller
+// levels are skipped, since there is too little left of the target to evolve.
+const pyramidMinSize = 16
+
+// A PyramidLevel is the target, and optionally the focus map, downsampled for
+// one step of coarse-to-fine evolution
+type PyramidLevel struct {
+	Factor float32 // Size of the level relative to the full size target
+	Target image.Image
+	Focus  image.Image
+}
+
+// BuildPyramid returns up to levels downsampled copies of the target, from
+// the coarsest to the full size target itself. Each level is scale times the
+// size of the next one.
+func BuildPyramid(target image.Image, focus image.Image, levels int, scale float32) []PyramidLevel {
+	size := target.Bounds().Size()
+	pyramid := []PyramidLevel{}
+	for i := levels - 1; i > 0; i-- {
+		factor := float32(math.Pow(float64(scale), float64(i)))
+		width := int(math.Round(float64(float32(size.X) * factor)))
+		height := int(math.Round(float64(float32(size.Y) * factor)))
+		if width < pyramidMinSize || height < pyramidMinSize || factor >= 1 {
+			log.Printf("Skipping pyramid level at %vx%v", width, height)
+			continue
+		}
+		level := PyramidLevel{
+			Factor: float32(width) / float32(size.X),
+			Target: downsampleImage(target, width, height),
+		}
+		if focus != nil {
+			level.Focus = downsampleImage(focus, width, height)
+		}
+		pyramid = append(pyramid, level)
+	}
+	return append(pyramid, PyramidLevel{Factor: 1, Target: target, Focus: focus})
+}
+
+// downsampleImage shrinks an image by averaging the pixels that are covered
+// by each pixel of the result
+func downsampleImage(source image.Image, width int, height int) *image.RGBA {
+	bounds := source.Bounds()
+	rgba := image.NewRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	draw.Draw(rgba, rgba.Bounds(), source, bounds.Min, draw.Src)
+	sourceWidth, sourceHeight := bounds.Dx(), bounds.Dy()
+
+	result := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		top := y * sourceHeight / height
+		bottom := (y + 1) * sourceHeight / height
+		if bottom <= top {
+			bottom = top + 1
+		}
+		for x := 0; x < width; x++ {
+			left := x * sourceWidth / width
+			right := (x + 1) * sourceWidth / width
+			if right <= left {
+				right = left + 1
+			}
+			var sum [4]int
+			for sy := top; sy < bottom; sy++ {
+				offset := rgba.PixOffset(left, sy)
+				for sx := left; sx < right; sx++ {
+					for c := 0; c < 4; c++ {
+						sum[c] += int(rgba.Pix[offset+c])
+					}
+					offset += 4
+				}
+			}
+			count := (bottom - top) * (right - left)
+			offset := result.PixOffset(x, y)
+			for c := 0; c < 4; c++ {
+				result.Pix[offset+c] = uint8((sum[c] + count/2) / count)
+			}
+		}
+	}
+	return result
+}
@@ -5,6 +5,8 @@ import (
 	"image"
 	"image/color"
 	"math"
+
+	colorful "github.com/lucasb-eyer/go-colorful"
 )
 
 // Lab represents a color in the Lab color space.
@@ -30,70 +32,143 @@ type LabCacheRequest struct {
 }
 
 // A Ranker calculates the difference between two images
-// by comparing their pixel colors in the Lab color space
+// using a FitnessMetric
 type Ranker struct {
-	precalculatedImage [][]*Lab
+	metric              FitnessMetric
+	complexityThreshold int
+	complexityPenalty   float32
 }
 
-func NewRanker() *Ranker {
+// NewRanker returns a new Ranker that scores images with the specified metric
+func NewRanker(metric FitnessMetric) *Ranker {
 	ranker := new(Ranker)
+	ranker.metric = metric
 	return ranker
 }
 
-// PrecalculateLabs pre-calculates Lab colors for an image to avoid
-// recomputing them on each comparison
-func (ranker *Ranker) PrecalculateLabs(image image.Image) {
+// SetComplexityPenalty makes Fitness add penalty to the diff for each
+// instruction over threshold
+func (ranker *Ranker) SetComplexityPenalty(threshold int, penalty float32) {
+	ranker.complexityThreshold = threshold
+	ranker.complexityPenalty = penalty
+}
+
+// Fitness returns the diff of an organism with the specified number of
+// instructions, including the complexity penalty
+func (ranker *Ranker) Fitness(rawDiff float32, instructionCount int) float32 {
+	if excess := instructionCount - ranker.complexityThreshold; excess > 0 {
+		return rawDiff + float32(excess)*ranker.complexityPenalty
+	}
+	return rawDiff
+}
+
+// Metric returns the metric used by the ranker
+func (ranker *Ranker) Metric() FitnessMetric {
+	return ranker.metric
+}
+
+// FormatProgress formats a diff as a progress complete percentage for the
+// ranker's metric.
+func (ranker *Ranker) FormatProgress(diff float32) string {
+	return FormatProgress(diff, ranker.metric.MaxDiff())
+}
+
+// Precalculate prepares the ranker to compare images against the target
+// to avoid recomputing target values on each comparison
+func (ranker *Ranker) Precalculate(target image.Image) {
+	ranker.metric.Precalculate(target)
+}
+
+// precalculateLabs converts every pixel of an image to Lab with the given conversion
+func precalculateLabs(image image.Image, getLab func(color.Color) *Lab) [][]*Lab {
 	size := image.Bounds().Size()
-	ranker.precalculatedImage = make([][]*Lab, size.X)
+	labs := make([][]*Lab, size.X)
 	for x := 0; x < size.X; x++ {
 		column := make([]*Lab, size.Y)
 		for y := 0; y < size.Y; y++ {
-			lab := ranker.getLab(image.At(x, y))
-			column[y] = lab
+			column[y] = getLab(image.At(x, y))
 		}
-		ranker.precalculatedImage[x] = column
+		labs[x] = column
 	}
+	return labs
 }
 
-func (ranker *Ranker) getLab(clr color.Color) *Lab {
+// cachedLab looks up the Lab for a color in the cache, converting it if needed
+func cachedLab(cache map[uint32]*Lab, clr color.Color, getLab func(color.Color) *Lab) *Lab {
+	key := ColorKey(clr)
+	lab, has := cache[key]
+	if !has {
+		lab = getLab(clr)
+		cache[key] = lab
+	}
+	return lab
+}
+
+// getLab converts a color to the Lab scale used by the original ranker,
+// where black to white has a distance of 94.
+func getLab(clr color.Color) *Lab {
 	r, g, b, _ := clr.RGBA()
 	l, a, b2 := MakeColorRGB(r, g, b).Lab()
 	return NewLab(float32(l), float32(a), float32(b2))
 }
 
+// getStandardLab converts a color to CIELAB with L in [0, 100]
+func getStandardLab(clr color.Color) *Lab {
+	r, g, b, _ := clr.RGBA()
+	l, a, b2 := colorful.Color{
+		R: float64(r) / 0xffff,
+		G: float64(g) / 0xffff,
+		B: float64(b) / 0xffff,
+	}.Lab()
+	return NewLab(float32(l*100), float32(a*100), float32(b2*100))
+}
+
+// RenderAreas returns the areas that have to be rendered to score changes to
+// the affected areas. Metrics that look at neighborhoods score pixels up to
+// their radius around an area, and compare each of them with pixels up to
+// the radius further out.
+func (ranker *Ranker) RenderAreas(areas []Rect, width int, height int) []Rect {
+	radius := float32(2 * ranker.metric.Radius())
+	if radius == 0 {
+		return areas
+	}
+	expanded := make([]Rect, 0, len(areas))
+	for _, area := range areas {
+		expanded = append(expanded, Rect{
+			Left:   float32(math.Max(0, float64(area.Left-radius))),
+			Top:    float32(math.Max(0, float64(area.Top-radius))),
+			Right:  float32(math.Min(float64(width), float64(area.Right+radius))),
+			Bottom: float32(math.Min(float64(height), float64(area.Bottom+radius))),
+		})
+	}
+	return expanded
+}
+
 func (ranker *Ranker) DistanceFromPrecalculatedBounds(image image.Image, boundAreas []Rect, diffMap *DiffMap) (float32, error) {
-	// Keep a cache of color mappings for these images
-	cache := map[uint32]*Lab{}
+	size := image.Bounds().Size()
+	// Changes can affect the diff of nearby pixels for metrics that look at neighborhoods
+	radius := ranker.metric.Radius()
 	for _, bounds := range boundAreas {
-		left := int(math.Floor(float64(bounds.Left)))
+		left := int(math.Floor(float64(bounds.Left))) - radius
 		if left < 0 {
 			left = 0
 		}
-		top := int(math.Floor(float64(bounds.Top)))
+		top := int(math.Floor(float64(bounds.Top))) - radius
 		if top < 0 {
 			top = 0
 		}
-		right := int(math.Ceil(float64(bounds.Right)))
-		if right >= image.Bounds().Size().X {
-			right = image.Bounds().Size().X - 1
+		right := int(math.Ceil(float64(bounds.Right))) + radius
+		if right > size.X {
+			right = size.X
 		}
-		bottom := int(math.Ceil(float64(bounds.Bottom)))
-		if bottom >= image.Bounds().Size().Y {
-			bottom = image.Bounds().Size().Y - 1
+		bottom := int(math.Ceil(float64(bounds.Bottom))) + radius
+		if bottom > size.Y {
+			bottom = size.Y
 		}
-		for x := left; x < right; x++ {
-			for y := top; y < bottom; y++ {
-				lab1 := ranker.precalculatedImage[x][y]
-				color2 := image.At(x, y)
-				color2Key := ColorKey(color2)
-				lab2, has := cache[color2Key]
-				if !has {
-					lab2 = ranker.getLab(color2)
-					cache[color2Key] = lab2
-				}
-				diffMap.SetDiff(x, y, ranker.colorDistance(lab1, lab2))
-			}
+		if left >= right || top >= bottom {
+			continue
 		}
+		ranker.metric.Diff(image, left, top, right, bottom, diffMap)
 	}
 	return diffMap.GetAverageDiff(), nil
 }
@@ -109,46 +184,19 @@ func (ranker *Ranker) DistanceFromPrecalculated(image image.Image, diffMap *Diff
 	return ranker.DistanceFromPrecalculatedBounds(image, bounds, diffMap)
 }
 
-// Distance calculates the distance between two images by comparing each pixel
-// between the images in the Lab color space.
+// Distance calculates the distance between two images. The first image is
+// treated as the target. A separate instance of the metric is used, so the
+// target the ranker has precalculated is kept.
 func (ranker *Ranker) Distance(image1 image.Image, image2 image.Image) (float32, error) {
 	if image1.Bounds().Size().X != image2.Bounds().Size().X || image1.Bounds().Size().Y != image2.Bounds().Size().Y {
 		return 0, fmt.Errorf("Images are not the same size")
 	}
-	// Keep a cache of color mappings for these images
-	cache := map[uint32]*Lab{}
-	var diff float32
-	var count float32
-	for x := 0; x < image1.Bounds().Size().X; x++ {
-		for y := 0; y < image1.Bounds().Size().Y; y++ {
-			color1 := image1.At(x, y)
-			color1Key := ColorKey(color1)
-			lab1, has := cache[color1Key]
-			if !has {
-				lab1 = ranker.getLab(color1)
-				cache[color1Key] = lab1
-			}
-			color2 := image2.At(x, y)
-			color2Key := ColorKey(color2)
-			lab2, has := cache[color2Key]
-			if !has {
-				lab2 = ranker.getLab(color2)
-				cache[color2Key] = lab2
-			}
-			diff += ranker.colorDistance(lab1, lab2)
-			count++
-		}
+	metric, err := NewFitnessMetric(ranker.metric.Name(), config)
+	if err != nil {
+		return 0, err
 	}
-	return diff / count, nil
-}
-
-// Calculates the distance between two colors using the Lab color space
-func (ranker *Ranker) colorDistance(lab1 *Lab, lab2 *Lab) float32 {
-	lDiff := lab2.l - lab1.l
-	lDiff = lDiff * lDiff
-	aDiff := lab2.a - lab1.a
-	aDiff = aDiff * aDiff
-	bDiff := lab2.b - lab1.b
-	bDiff = bDiff * bDiff
-	return float32(math.Sqrt(float64(lDiff) + float64(aDiff) + float64(bDiff)))
+	adhoc := NewRanker(metric)
+	adhoc.Precalculate(image1)
+	diffMap := NewDiffMap(image1.Bounds().Size().X, image1.Bounds().Size().Y)
+	return adhoc.DistanceFromPrecalculated(image2, diffMap)
 }
@@ -10,6 +10,10 @@ type Rect struct {
 	Bottom float32
 }
 
+// CanvasRect covers every pixel of any canvas. It is the affected area of
+// changes that aren't limited to a region, like the background color.
+var CanvasRect = Rect{Left: 0, Top: 0, Right: 1 << 24, Bottom: 1 << 24}
+
 // Area returns the area of the Rect. Optionally will round
 // coordinates before calculating.
 func (rect Rect) Area(roundCoordinates bool) float32 {
@@ -2,7 +2,6 @@ package main
 
 import (
 	"image"
-	"image/color"
 
 	"github.com/fogleman/gg"
 )
@@ -19,31 +18,29 @@ func NewRenderer(width int, height int) *Renderer {
 	return renderer
 }
 
-// Render will apply a set of instructions to render an image
-func (renderer *Renderer) Render(instructions []Instruction) {
-	// TODO: organism picks the background color?
-	renderer.ctx.SetColor(color.Black)
-	renderer.ctx.DrawRectangle(0, 0, float64(renderer.ctx.Width()), float64(renderer.ctx.Height()))
-	renderer.ctx.Fill()
-	for _, instruction := range instructions {
+// Render will apply an organism's instructions to render an image
+func (renderer *Renderer) Render(organism *Organism) {
+	renderer.ctx.SetColor(organism.Background)
+	renderer.ctx.Clear()
+	for _, instruction := range organism.Instructions {
 		instruction.Execute(renderer.ctx)
 	}
 }
 
-// RenderBounds will apply a set of bounds-filtered instructions to render an image. Any instructions
+// RenderBounds will apply an organism's bounds-filtered instructions to render an image. Any instructions
 // that intersect the bounds will be rendered, all other instructions are ignored.
-func (renderer *Renderer) RenderBounds(instructions []Instruction, bounds []Rect) {
-	// TODO: organism picks the background color?
-	renderer.ctx.SetColor(color.Black)
-	renderer.ctx.DrawRectangle(0, 0, float64(renderer.ctx.Width()), float64(renderer.ctx.Height()))
-	renderer.ctx.Fill()
-	for _, instruction := range instructions {
+func (renderer *Renderer) RenderBounds(organism *Organism, bounds []Rect) {
+	renderer.ctx.SetColor(organism.Background)
+	renderer.ctx.Clear()
+	for _, instruction := range organism.Instructions {
+		// Each instruction is drawn at most once, since drawing translucent
+		// instructions twice would composite them twice
 		for i := range bounds {
 			if instruction.Bounds().Intersects(&bounds[i]) {
 				instruction.Execute(renderer.ctx)
+				break
 			}
 		}
-
 	}
 }
 
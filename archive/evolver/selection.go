@@ -0,0 +1,60 @@
+package main
+
+import "fmt"
+
+const (
+	// SelectionGreedy - only keep organisms that improve on the top organism
+	SelectionGreedy = "greedy"
+	// SelectionAnnealing - simulated annealing, sometimes accepting worse organisms
+	SelectionAnnealing = "annealing"
+	// SelectionTournament - breed from a retained population using tournaments
+	SelectionTournament = "tournament"
+)
+
+// A SelectionStrategy decides which organisms survive each iteration of an
+// Incubator, and which organisms the next generation is bred from. Strategies
+// are only used from the incubator goroutine.
+type SelectionStrategy interface {
+	Name() string
+	// Parent returns the organism that the next child should be cloned from
+	Parent(incubator *Incubator) *Organism
+	// Select is called once the current generation has been scored and
+	// sorted. It must clear the current generation, disposing of every
+	// organism it doesn't keep, and update the incubator's top organism
+	// when an improvement is found.
+	Select(incubator *Incubator)
+	// Reset discards any retained organisms. It is called when the top
+	// organism is replaced from outside the incubator.
+	Reset(incubator *Incubator)
+	// Status describes the state of the strategy for progress logs
+	Status() string
+}
+
+// A ParentPairSelector is a SelectionStrategy that retains several organisms,
+// so that pairs of them can be recombined by crossover.
+type ParentPairSelector interface {
+	// ParentPair returns two different parents, or false if fewer than two
+	// organisms are retained
+	ParentPair(incubator *Incubator) (*Organism, *Organism, bool)
+}
+
+// NewSelectionStrategy returns the selection strategy with the specified name
+func NewSelectionStrategy(name string, config *Config) (SelectionStrategy, error) {
+	switch name {
+	case SelectionGreedy, "":
+		return NewGreedySelection(), nil
+	case SelectionAnnealing:
+		return NewAnnealingSelection(config.AnnealingTemperature, config.AnnealingCooling), nil
+	case SelectionTournament:
+		return NewTournamentSelection(config.TournamentPopulation, config.TournamentSize), nil
+	}
+	return nil, fmt.Errorf("Unknown selection strategy '%v'", name)
+}
+
+// takeCurrentGeneration returns the current generation of the incubator and
+// clears it, leaving the caller responsible for the organisms.
+func takeCurrentGeneration(incubator *Incubator) []*Organism {
+	generation := append([]*Organism{}, incubator.currentGeneration...)
+	incubator.clearCurrentGeneration()
+	return generation
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+)
+
+// AnnealingSelection is simulated annealing. The incubator breeds from a
+// current organism that is replaced by the best child of each generation if
+// the child is better, or with a probability that shrinks as the child gets
+// worse and as the temperature cools. This lets the population escape local
+// minima that greedy selection gets stuck in.
+type AnnealingSelection struct {
+	temperature float64
+	cooling     float64
+	current     *Organism
+	accepted    int
+	rejected    int
+}
+
+// NewAnnealingSelection returns a new `AnnealingSelection`. The temperature
+// is in units of average pixel diff, and is multiplied by `cooling` after
+// every iteration.
+func NewAnnealingSelection(temperature float32, cooling float32) *AnnealingSelection {
+	selection := new(AnnealingSelection)
+	selection.temperature = float64(temperature)
+	selection.cooling = float64(cooling)
+	return selection
+}
+
+func (selection *AnnealingSelection) Name() string {
+	return SelectionAnnealing
+}
+
+func (selection *AnnealingSelection) Parent(incubator *Incubator) *Organism {
+	if selection.current == nil {
+		return incubator.topOrganism
+	}
+	return selection.current
+}
+
+func (selection *AnnealingSelection) Select(incubator *Incubator) {
+	generation := takeCurrentGeneration(incubator)
+	defer func() {
+		selection.temperature *= selection.cooling
+	}()
+	if len(generation) == 0 {
+		return
+	}
+	// Only the best child competes with the current organism
+	candidate := generation[0]
+	for _, organism := range generation[1:] {
+		incubator.disposeOrganism(organism)
+	}
+
+	delta := float64(candidate.Diff - selection.Parent(incubator).Diff)
+	if delta >= 0 && (selection.temperature <= 0 || rand.Float64() >= math.Exp(-delta/selection.temperature)) {
+		selection.rejected++
+		incubator.disposeOrganism(candidate)
+		return
+	}
+	selection.accepted++
+	if selection.current != nil {
+		incubator.disposeOrganism(selection.current)
+	}
+	selection.current = candidate
+	if candidate.Diff < incubator.topOrganism.Diff {
+		incubator.promoteOrganism(candidate)
+	}
+}
+
+func (selection *AnnealingSelection) Reset(incubator *Incubator) {
+	if selection.current != nil {
+		incubator.disposeOrganism(selection.current)
+		selection.current = nil
+	}
+}
+
+func (selection *AnnealingSelection) Status() string {
+	current := "top"
+	if selection.current != nil {
+		current = fmt.Sprint(selection.current.Diff)
+	}
+	return fmt.Sprintf(
+		"annealing temperature=%.6f current=%v accepted=%v rejected=%v",
+		selection.temperature, current, selection.accepted, selection.rejected)
+}
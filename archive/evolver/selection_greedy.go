@@ -0,0 +1,43 @@
+package main
+
+// GreedySelection is strict hill climbing. Children are always bred from the
+// top organism, and only children that improve on it survive.
+type GreedySelection struct{}
+
+// NewGreedySelection returns a new `GreedySelection`
+func NewGreedySelection() *GreedySelection {
+	return &GreedySelection{}
+}
+
+func (selection *GreedySelection) Name() string {
+	return SelectionGreedy
+}
+
+func (selection *GreedySelection) Parent(incubator *Incubator) *Organism {
+	return incubator.topOrganism
+}
+
+func (selection *GreedySelection) Select(incubator *Incubator) {
+	improved := []*Organism{}
+	for _, organism := range takeCurrentGeneration(incubator) {
+		if organism.Diff < incubator.topOrganism.Diff {
+			improved = append(improved, organism)
+		} else {
+			// dispose of all organisms/patches that did not lead to improvements
+			incubator.disposeOrganism(organism)
+		}
+	}
+	if len(improved) == 1 {
+		incubator.setTopOrganism(improved[0], false)
+	} else if len(improved) > 1 {
+		// Try to apply all of the improvements to the last top organism
+		// so that no improvements are lost.
+		incubator.combineImprovements(improved)
+	}
+}
+
+func (selection *GreedySelection) Reset(incubator *Incubator) {}
+
+func (selection *GreedySelection) Status() string {
+	return SelectionGreedy
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"sort"
+)
+
+// TournamentSelection keeps a population of the best organisms found so far.
+// Each child is bred from the winner of a tournament between randomly chosen
+// members of the population, and children replace the worst members when
+// they are better. Keeping several lineages alive preserves diversity that
+// greedy selection throws away.
+type TournamentSelection struct {
+	populationSize int
+	tournamentSize int
+	population     []*Organism
+}
+
+// NewTournamentSelection returns a new `TournamentSelection`
+func NewTournamentSelection(populationSize int, tournamentSize int) *TournamentSelection {
+	selection := new(TournamentSelection)
+	selection.populationSize = populationSize
+	if selection.populationSize < 1 {
+		selection.populationSize = 1
+	}
+	selection.tournamentSize = tournamentSize
+	if selection.tournamentSize < 1 {
+		selection.tournamentSize = 1
+	}
+	selection.population = make([]*Organism, 0, selection.populationSize)
+	return selection
+}
+
+func (selection *TournamentSelection) Name() string {
+	return SelectionTournament
+}
+
+func (selection *TournamentSelection) Parent(incubator *Incubator) *Organism {
+	if len(selection.population) == 0 {
+		return incubator.topOrganism
+	}
+	var winner *Organism
+	for i := 0; i < selection.tournamentSize; i++ {
+		contestant := selection.population[rand.Intn(len(selection.population))]
+		if winner == nil || contestant.Diff < winner.Diff {
+			winner = contestant
+		}
+	}
+	return winner
+}
+
+// ParentPair runs two tournaments to pick parents for crossover
+func (selection *TournamentSelection) ParentPair(incubator *Incubator) (*Organism, *Organism, bool) {
+	if len(selection.population) < 2 {
+		return nil, nil, false
+	}
+	parent1 := selection.Parent(incubator)
+	parent2 := selection.Parent(incubator)
+	// Tournaments favor the same winners, fall back to a random partner
+	for parent2 == parent1 {
+		parent2 = selection.population[rand.Intn(len(selection.population))]
+	}
+	return parent1, parent2, true
+}
+
+func (selection *TournamentSelection) Select(incubator *Incubator) {
+	hashes := objectPool.BorrowStringset()
+	for _, organism := range selection.population {
+		hashes[organism.Hash()] = true
+	}
+	for _, organism := range takeCurrentGeneration(incubator) {
+		if hashes[organism.Hash()] {
+			incubator.disposeOrganism(organism)
+			continue
+		}
+		hashes[organism.Hash()] = true
+		selection.population = append(selection.population, organism)
+	}
+	objectPool.ReturnStringset(hashes)
+
+	sort.Sort(OrganismList(selection.population))
+	if len(selection.population) > selection.populationSize {
+		for _, organism := range selection.population[selection.populationSize:] {
+			incubator.disposeOrganism(organism)
+		}
+		selection.population = selection.population[:selection.populationSize]
+	}
+	if len(selection.population) > 0 && selection.population[0].Diff < incubator.topOrganism.Diff {
+		incubator.promoteOrganism(selection.population[0])
+	}
+}
+
+func (selection *TournamentSelection) Reset(incubator *Incubator) {
+	for _, organism := range selection.population {
+		incubator.disposeOrganism(organism)
+	}
+	selection.population = selection.population[:0]
+}
+
+func (selection *TournamentSelection) Status() string {
+	if len(selection.population) == 0 {
+		return "tournament population=0"
+	}
+	return fmt.Sprintf(
+		"tournament population=%v worst=%v",
+		len(selection.population), selection.population[len(selection.population)-1].Diff)
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"image"
 	"image/png"
 	"log"
@@ -16,27 +17,82 @@ import (
 // apply incoming patch to current top organism
 // send out top organism as patch, using history as reference (along with expected hash)
 
+// recentOrganismCount is the number of recent top organisms kept to verify
+// submitted patches against
+const recentOrganismCount = 16
+
+// streamKeepaliveInterval is how often idle update streams send a ping, so
+// that dead connections are noticed
+const streamKeepaliveInterval = time.Second * 15
+
+// serverShutdownTimeout is how long requests in progress may take to finish
+// when the portal stops
+const serverShutdownTimeout = time.Second * 10
+
 // ServerPortal provides http handlers (designed for the gin framework) to
 // check out work items and submit results
 type ServerPortal struct {
 	incubator      *Incubator
-	organismCache  *PatchCache
+	journal        *PatchJournal
+	journalTop     *Organism // the organism of the last journal entry
 	patchProcessor *PatchProcessor
+	patchValidator *PatchValidator
+	// recent top organisms by hash, oldest first
+	recentOrganisms     map[string]*Organism
+	recentOrganismOrder []string
+	roster              *WorkerRoster
+	// update streams, each receiving the hash of new top organisms
+	subscribers   map[chan string]bool
+	lastBroadcast string
 
 	// communication channels
-	patchRequestChan chan *GetPatchRequest
-	updateChan       chan *UpdateRequest
-	focusImageData   []byte
+	updateChan      chan *UpdateRequest
+	validateChan    chan *ValidatePatchRequest
+	registerChan    chan *RegisterWorkerRequest
+	heartbeatChan   chan *HeartbeatRequest
+	rosterChan      chan *RosterRequest
+	subscribeChan   chan *SubscribeRequest
+	unsubscribeChan chan chan string
+	focusImageData  []byte
+	stopping        <-chan struct{}
+	stopped         chan struct{}
+
+	// listening
+	address        string
+	tlsCertFile    string
+	tlsKeyFile     string
+	authSecret     string
+	maxRequestSize int64 // Largest body of a signed request
 }
 
-// NewServerPortal returns a new ServerPortal
-func NewServerPortal(incubator *Incubator, focusImage image.Image) *ServerPortal {
+// NewServerPortal returns a new ServerPortal that listens on address. It
+// records every new top organism in the journal, which it closes once it has
+// stopped. It serves https if tlsCertFile and tlsKeyFile are set, and
+// requires workers to sign their requests if authSecret is set.
+func NewServerPortal(incubator *Incubator, focusImage image.Image, journal *PatchJournal, address string, tlsCertFile string, tlsKeyFile string, authSecret string, maxRequestSize int64) *ServerPortal {
 	handler := new(ServerPortal)
 	handler.incubator = incubator
+	handler.journal = journal
+	handler.address = address
+	handler.tlsCertFile = tlsCertFile
+	handler.tlsKeyFile = tlsKeyFile
+	handler.authSecret = authSecret
+	handler.maxRequestSize = maxRequestSize
 	handler.patchProcessor = &PatchProcessor{}
-	handler.organismCache = NewPatchCache()
-	handler.patchRequestChan = make(chan *GetPatchRequest)
+	bounds := incubator.target.Bounds()
+	handler.patchValidator = NewPatchValidator(bounds.Dx(), bounds.Dy())
+	handler.recentOrganisms = map[string]*Organism{}
+	handler.roster = NewWorkerRoster()
+	handler.subscribers = map[chan string]bool{}
 	handler.updateChan = make(chan *UpdateRequest)
+	handler.validateChan = make(chan *ValidatePatchRequest)
+	handler.registerChan = make(chan *RegisterWorkerRequest)
+	handler.heartbeatChan = make(chan *HeartbeatRequest)
+	handler.rosterChan = make(chan *RosterRequest)
+	handler.subscribeChan = make(chan *SubscribeRequest)
+	handler.unsubscribeChan = make(chan chan string)
+	handler.stopped = make(chan struct{})
+	handler.journalTop = journalTip(journal)
 	if focusImage != nil {
 		buf := &bytes.Buffer{}
 		png.Encode(buf, focusImage)
@@ -45,66 +101,244 @@ func NewServerPortal(incubator *Incubator, focusImage image.Image) *ServerPortal
 	return handler
 }
 
-// Start begins listening on http port 8000 for external requests.
-func (handler *ServerPortal) Start() {
-	handler.startRequestHandler()
+// Start begins listening for external requests. When the
+// context is cancelled, the portal finishes the requests in progress and
+// stops; Done is closed once it has.
+func (handler *ServerPortal) Start(ctx context.Context) {
+	handler.stopping = ctx.Done()
+	handler.startRequestHandler(ctx)
 	handler.startBackgroundRoutine()
 }
 
+// Done returns a channel that is closed once the portal has stopped
+func (handler *ServerPortal) Done() <-chan struct{} {
+	return handler.stopped
+}
+
+// startBackgroundRoutine serves requests from http handlers until the http
+// server has stopped, so that requests in progress can finish
 func (handler *ServerPortal) startBackgroundRoutine() {
 	go func() {
 		for {
 			select {
-			case req := <-handler.patchRequestChan:
-				req.Callback <- handler.organismCache.GetPatch(req.Baseline, req.Target, true)
+			case <-handler.stopped:
+				err := handler.journal.Close()
+				if err != nil {
+					log.Printf("Error closing patch journal: %v", err.Error())
+				}
+				return
 			case req := <-handler.updateChan:
 				topOrganism := handler.incubator.GetTopOrganism()
-				_, has := handler.organismCache.Get(topOrganism.Hash())
-				if !has {
-					if topOrganism.Patch == nil {
-						emptyPatch := objectPool.BorrowPatch()
-						emptyPatch.Baseline = "<none>"
-						emptyPatch.Target = topOrganism.Hash()
-						handler.organismCache.Put(topOrganism.Hash(), emptyPatch)
-					} else {
-						handler.organismCache.Put(topOrganism.Hash(), topOrganism.Patch.Clone())
-					}
-				}
-				objectPool.ReturnOrganism(topOrganism)
+				handler.recordTopOrganism(topOrganism)
+				handler.broadcast(topOrganism.Hash())
+				handler.recordRecentOrganism(topOrganism)
 
 				req.Callback <- true
+			case req := <-handler.validateChan:
+				err := req.Error
+				if err == nil {
+					baseline := handler.recentOrganisms[req.Patch.Baseline]
+					if baseline == nil {
+						baseline = req.Baseline
+					}
+					err = handler.patchValidator.Validate(req.Patch, baseline)
+				}
+				// Patches from baselines that aren't recent are tried again
+				// once the baseline has been rebuilt from the journal
+				retry := req.Error == nil && req.Baseline == nil && err != nil && err.Code == PatchErrorUnknownBaseline
+				if !retry {
+					worker := handler.roster.RecordSubmission(req.Worker, req.Address, err == nil)
+					if err != nil {
+						log.Printf("Rejected patch from %v (%v rejections): %v", req.Worker, worker.Rejected, err.Error())
+					}
+				}
+				req.Callback <- err
+			case req := <-handler.registerChan:
+				worker := handler.roster.Register(req.Registration, req.Address)
+				log.Printf("Registered worker %v (%v at %v, %v cpus)", worker.ID, worker.Hostname, worker.Address, worker.CPUs)
+				req.Callback <- worker.ID
+			case req := <-handler.heartbeatChan:
+				req.Callback <- handler.roster.Heartbeat(req.Worker, req.Heartbeat)
+			case req := <-handler.rosterChan:
+				req.Callback <- handler.roster.List()
+			case req := <-handler.subscribeChan:
+				updates := make(chan string, 1)
+				handler.subscribers[updates] = true
+				req.Callback <- updates
+			case updates := <-handler.unsubscribeChan:
+				delete(handler.subscribers, updates)
 			}
 		}
 	}()
 }
 
-func (handler *ServerPortal) startRequestHandler() {
+func (handler *ServerPortal) startRequestHandler(ctx context.Context) {
 	// Http handler
+	r := gin.New()
+	// Compression would hold back streamed events
+	r.Use(gzip.Gzip(gzip.BestCompression, gzip.WithExcludedPaths([]string{"/organism/stream"})))
+	r.GET("/", func(ctx *gin.Context) {
+		ctx.Data(http.StatusOK, "text/plain", []byte("Service is up!"))
+	})
+	// Everything but the status page is restricted to workers
+	api := r.Group("/")
+	if handler.authSecret != "" {
+		api.Use(AuthMiddleware(handler.authSecret, handler.maxRequestSize))
+	}
+	// r.GET("/work-item", handler.GetWorkItem)
+	// r.POST("/result", handler.SubmitResult)
+	api.GET("/organism/delta", handler.GetTopOrganismDelta)
+	api.GET("/organism/stream", handler.StreamTopOrganism)
+	api.GET("/organism", handler.GetTopOrganism)
+	api.POST("/organism", handler.SubmitOrganism)
+	api.GET("/target", handler.GetTargetImageData)
+	api.GET("/focus", handler.GetFocusImageData)
+	api.POST("/workers", handler.RegisterWorker)
+	api.POST("/workers/:id/heartbeat", handler.WorkerHeartbeat)
+	api.GET("/workers", handler.GetWorkers)
+	server := &http.Server{
+		Addr:    handler.address,
+		Handler: r,
+	}
+	useTLS := handler.tlsCertFile != "" || handler.tlsKeyFile != ""
+	if useTLS && (handler.tlsCertFile == "" || handler.tlsKeyFile == "") {
+		log.Fatalf("Both a TLS certificate and key file are required to serve https")
+	}
+	if handler.authSecret == "" {
+		log.Println("Warning: no auth secret is set, anyone can submit organisms")
+	}
+	log.Printf("Listening on %v (tls=%v, auth=%v)", handler.address, useTLS, handler.authSecret != "")
 	go func() {
-		r := gin.New()
-		r.Use(gzip.Gzip(gzip.BestCompression))
-		r.GET("/", func(ctx *gin.Context) {
-			ctx.Data(http.StatusOK, "text/plain", []byte("Service is up!"))
-		})
-		// r.GET("/work-item", handler.GetWorkItem)
-		// r.POST("/result", handler.SubmitResult)
-		r.GET("/organism/delta", handler.GetTopOrganismDelta)
-		r.GET("/organism", handler.GetTopOrganism)
-		r.POST("/organism", handler.SubmitOrganism)
-		r.GET("/target", handler.GetTargetImageData)
-		r.GET("/focus", handler.GetFocusImageData)
-		http.ListenAndServe("0.0.0.0:8000", r)
+		var err error
+		if useTLS {
+			err = server.ListenAndServeTLS(handler.tlsCertFile, handler.tlsKeyFile)
+		} else {
+			err = server.ListenAndServe()
+		}
+		if err != nil && err != http.ErrServerClosed {
+			log.Fatalf("Error serving http: %v", err.Error())
+		}
+	}()
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), serverShutdownTimeout)
+		defer cancel()
+		err := server.Shutdown(shutdownCtx)
+		if err != nil {
+			log.Printf("Error stopping http server: %v", err.Error())
+		}
+		close(handler.stopped)
 	}()
 	time.Sleep(time.Millisecond * 100)
 }
 
-// Update makes sure that the current top organism is cached.
+// recordTopOrganism appends a patch from the previous top organism to the
+// journal, if the organism is new
+func (handler *ServerPortal) recordTopOrganism(organism *Organism) {
+	if !handler.journal.Has(organism.Hash()) {
+		patch := handler.verifiedDiff(handler.journalTop, organism)
+		err := handler.journal.Append(&JournalEntry{
+			Patch:     patch,
+			Iteration: handler.incubator.Iteration,
+			Diff:      organism.Diff,
+			Timestamp: time.Now(),
+		})
+		objectPool.ReturnPatch(patch)
+		if err != nil {
+			log.Printf("Error writing patch journal: %v", err.Error())
+			return
+		}
+		if handler.journal.CompactionDue(config.PatchJournalCompaction) {
+			err = handler.journal.Compact(organism.Hash())
+			if err != nil {
+				log.Printf("Error compacting patch journal: %v", err.Error())
+			}
+		}
+	}
+	if handler.journalTop != nil {
+		objectPool.ReturnOrganism(handler.journalTop)
+	}
+	handler.journalTop = organism.Clone()
+}
+
+// journalTip reconstructs the organism of the last journal entry, so that the
+// next entry continues its lineage. It returns nil if the journal is empty or
+// can't be replayed, in which case a new lineage is started.
+func journalTip(journal *PatchJournal) *Organism {
+	latest := journal.Latest()
+	if latest == "" {
+		return nil
+	}
+	organism, err := journal.Organism(latest)
+	if err != nil {
+		log.Printf("Error replaying patch journal, starting a new lineage: %v", err.Error())
+		return nil
+	}
+	return organism
+}
+
+// verifiedDiff returns a patch from one organism to another that has been
+// checked to produce the target. If the diff doesn't, for example because of
+// duplicate instructions, a patch replacing all instructions is returned. A
+// patch from an empty organism is only returned without a previous organism.
+func (handler *ServerPortal) verifiedDiff(from *Organism, to *Organism) *Patch {
+	if from == nil {
+		return DiffOrganisms(nil, to)
+	}
+	patch := DiffOrganisms(from, to)
+	result := handler.patchProcessor.ProcessPatch(from, patch)
+	matches := result.Hash() == to.Hash()
+	objectPool.ReturnOrganism(result)
+	if matches {
+		return patch
+	}
+	log.Printf("Diff from %v to %v doesn't verify, replacing all instructions", from.Hash(), to.Hash())
+	objectPool.ReturnPatch(patch)
+	return ReplacementPatch(from, to)
+}
+
+// broadcast notifies update streams of a new top organism. Streams that
+// haven't caught up only get the latest hash.
+func (handler *ServerPortal) broadcast(hash string) {
+	if hash == handler.lastBroadcast {
+		return
+	}
+	handler.lastBroadcast = hash
+	for updates := range handler.subscribers {
+		select {
+		case <-updates:
+		default:
+		}
+		updates <- hash
+	}
+}
+
+// recordRecentOrganism keeps the organism so that patches made from it can be
+// verified, and forgets the oldest one when there are too many
+func (handler *ServerPortal) recordRecentOrganism(organism *Organism) {
+	if _, has := handler.recentOrganisms[organism.Hash()]; has {
+		objectPool.ReturnOrganism(organism)
+		return
+	}
+	handler.recentOrganisms[organism.Hash()] = organism
+	handler.recentOrganismOrder = append(handler.recentOrganismOrder, organism.Hash())
+	if len(handler.recentOrganismOrder) > recentOrganismCount {
+		oldest := handler.recentOrganismOrder[0]
+		handler.recentOrganismOrder = handler.recentOrganismOrder[1:]
+		objectPool.ReturnOrganism(handler.recentOrganisms[oldest])
+		delete(handler.recentOrganisms, oldest)
+	}
+}
+
+// Update makes sure that the current top organism is cached. It does nothing
+// once the portal has stopped.
 func (handler *ServerPortal) Update() {
 	callback := make(chan bool)
-	handler.updateChan <- &UpdateRequest{
-		Callback: callback,
+	select {
+	case handler.updateChan <- &UpdateRequest{Callback: callback}:
+		<-callback
+	case <-handler.stopped:
 	}
-	<-callback
 }
 
 func (handler *ServerPortal) GetTargetImageData(ctx *gin.Context) {
@@ -153,21 +387,7 @@ func (handler *ServerPortal) GetTopOrganismDelta(ctx *gin.Context) {
 		objectPool.ReturnPatch(patch)
 		return
 	}
-	// Ensure topOrganism is in the cache
-	if topOrganism.Patch != nil {
-		_, stored := handler.organismCache.Get(topOrganism.Hash())
-		if !stored {
-			handler.organismCache.Put(topOrganism.Hash(), topOrganism.Patch.Clone())
-		}
-	}
-
-	callback := make(chan *Patch)
-	handler.patchRequestChan <- &GetPatchRequest{
-		Baseline: previous,
-		Target:   topOrganism.Hash(),
-		Callback: callback,
-	}
-	patch := <-callback
+	patch := handler.journal.GetPatch(previous, topOrganism.Hash())
 	defer func() {
 		if patch != nil {
 
@@ -179,30 +399,238 @@ func (handler *ServerPortal) GetTopOrganismDelta(ctx *gin.Context) {
 		ctx.JSON(http.StatusNotFound, map[string]interface{}{"Message": "Previous organism not found"})
 		return
 	}
+	// Workers that are far behind are better off downloading the whole organism
+	if len(patch.Operations) > len(topOrganism.Instructions) {
+		log.Printf("GetTopOrganismDelta: %v is %v operations behind, sending whole organism instead", previous, len(patch.Operations))
+		ctx.JSON(http.StatusNotFound, map[string]interface{}{"Message": "Patch is larger than the organism"})
+		return
+	}
 
 	log.Printf("GetTopOrganismDelta: Sending %v -> %v, %v operations", previous, topOrganism.Hash(), len(patch.Operations))
 	ctx.JSON(http.StatusOK, patch)
 }
 
+// StreamTopOrganism streams changes of the top organism as server-sent
+// events. The first event is a "top" event with the hash of the current top
+// organism. Every change after that is sent as a "patch" event with a patch
+// from the previous top organism, or as a "top" event if there is no patch
+// for it in the cache.
+func (handler *ServerPortal) StreamTopOrganism(ctx *gin.Context) {
+	updates := handler.subscribe()
+	if updates == nil {
+		ctx.AbortWithStatus(http.StatusServiceUnavailable)
+		return
+	}
+	defer handler.unsubscribe(updates)
+	keepalive := time.NewTicker(streamKeepaliveInterval)
+	defer keepalive.Stop()
+
+	topOrganism := handler.incubator.GetTopOrganism()
+	previous := topOrganism.Hash()
+	objectPool.ReturnOrganism(topOrganism)
+	log.Printf("Streaming top organism to %v", workerName(ctx))
+	ctx.SSEvent("top", previous)
+	ctx.Writer.Flush()
+	for {
+		select {
+		case <-handler.stopping:
+			return
+		case <-ctx.Request.Context().Done():
+			return
+		case <-keepalive.C:
+			ctx.SSEvent("ping", "")
+		case target := <-updates:
+			if target == previous {
+				continue
+			}
+			patch := handler.journal.GetPatch(previous, target)
+			if patch == nil {
+				ctx.SSEvent("top", target)
+			} else {
+				ctx.SSEvent("patch", patch)
+				objectPool.ReturnPatch(patch)
+			}
+			previous = target
+		}
+		ctx.Writer.Flush()
+	}
+}
+
+// subscribe returns a channel that receives the hash of new top organisms,
+// or nil if the portal has stopped
+func (handler *ServerPortal) subscribe() chan string {
+	callback := make(chan chan string)
+	select {
+	case handler.subscribeChan <- &SubscribeRequest{Callback: callback}:
+		return <-callback
+	case <-handler.stopped:
+		return nil
+	}
+}
+
+func (handler *ServerPortal) unsubscribe(updates chan string) {
+	select {
+	case handler.unsubscribeChan <- updates:
+	case <-handler.stopped:
+	}
+}
+
+// SubmitOrganism validates a patch from a worker and queues it for the
+// incubator. Invalid patches are rejected with a PatchError.
 func (handler *ServerPortal) SubmitOrganism(ctx *gin.Context) {
+	worker := workerName(ctx)
 	patch := objectPool.BorrowPatch()
-	err := ctx.BindJSON(patch)
+	defer objectPool.ReturnPatch(patch)
+	err := ctx.ShouldBindJSON(patch)
 	if err != nil {
-		log.Printf("Error importing patch: '%v'", err.Error())
-		objectPool.ReturnPatch(patch)
-		ctx.AbortWithError(http.StatusBadRequest, err)
+		patchErr := newPatchError(http.StatusBadRequest, PatchErrorMalformed, -1, err.Error())
+		handler.countRejection(worker, ctx.ClientIP(), patchErr)
+		ctx.AbortWithStatusJSON(patchErr.Status, patchErr)
+		return
+	}
+	patchErr := handler.validatePatch(patch, nil, worker, ctx.ClientIP())
+	if patchErr != nil && patchErr.Code == PatchErrorUnknownBaseline {
+		// The baseline may have been the top organism long enough ago to
+		// no longer be recent
+		baseline, err := handler.journal.Organism(patch.Baseline)
+		if err == nil {
+			patchErr = handler.validatePatch(patch, baseline, worker, ctx.ClientIP())
+			objectPool.ReturnOrganism(baseline)
+		} else {
+			handler.countRejection(worker, ctx.ClientIP(), patchErr)
+		}
+	}
+	if patchErr != nil {
+		ctx.AbortWithStatusJSON(patchErr.Status, patchErr)
 		return
 	}
-	log.Printf("Importing patch '%v' -> '%v'", patch.Baseline, patch.Target)
+	log.Printf("Importing patch '%v' -> '%v' from %v", patch.Baseline, patch.Target, worker)
 	handler.incubator.SubmitPatch(patch)
 }
 
-// GetPatchRequest is a request to get a combined Patch that will transform
-// the baseline organism into the target organism
-type GetPatchRequest struct {
-	Baseline string
-	Target   string
-	Callback chan<- *Patch
+// validatePatch checks a patch in the portal goroutine. baseline is the
+// organism the patch was made from if it has been rebuilt from the journal.
+func (handler *ServerPortal) validatePatch(patch *Patch, baseline *Organism, worker string, address string) *PatchError {
+	callback := make(chan *PatchError)
+	handler.validateChan <- &ValidatePatchRequest{
+		Patch:    patch,
+		Baseline: baseline,
+		Worker:   worker,
+		Address:  address,
+		Callback: callback,
+	}
+	return <-callback
+}
+
+// countRejection records a patch that was rejected before validation
+func (handler *ServerPortal) countRejection(worker string, address string, err *PatchError) {
+	callback := make(chan *PatchError)
+	handler.validateChan <- &ValidatePatchRequest{
+		Worker:   worker,
+		Address:  address,
+		Error:    err,
+		Callback: callback,
+	}
+	<-callback
+}
+
+// RegisterWorker adds a worker to the roster and responds with its id
+func (handler *ServerPortal) RegisterWorker(ctx *gin.Context) {
+	registration := &WorkerRegistration{}
+	err := ctx.ShouldBindJSON(registration)
+	if err != nil {
+		ctx.AbortWithStatusJSON(http.StatusBadRequest, map[string]interface{}{"Message": err.Error()})
+		return
+	}
+	callback := make(chan string)
+	handler.registerChan <- &RegisterWorkerRequest{
+		Registration: registration,
+		Address:      ctx.ClientIP(),
+		Callback:     callback,
+	}
+	ctx.JSON(http.StatusOK, &WorkerRegistered{
+		ID:               <-callback,
+		HeartbeatSeconds: int(workerHeartbeatInterval / time.Second),
+	})
+}
+
+// WorkerHeartbeat records the progress of a registered worker. Unknown
+// workers, for example after the server restarted, get a 404 and should
+// register again.
+func (handler *ServerPortal) WorkerHeartbeat(ctx *gin.Context) {
+	heartbeat := &WorkerHeartbeat{}
+	err := ctx.ShouldBindJSON(heartbeat)
+	if err != nil {
+		ctx.AbortWithStatusJSON(http.StatusBadRequest, map[string]interface{}{"Message": err.Error()})
+		return
+	}
+	callback := make(chan bool)
+	handler.heartbeatChan <- &HeartbeatRequest{
+		Worker:    ctx.Param("id"),
+		Heartbeat: heartbeat,
+		Callback:  callback,
+	}
+	if !<-callback {
+		ctx.AbortWithStatusJSON(http.StatusNotFound, map[string]interface{}{"Message": "Unknown worker"})
+		return
+	}
+	ctx.Status(http.StatusOK)
+}
+
+// GetWorkers returns the worker roster
+func (handler *ServerPortal) GetWorkers(ctx *gin.Context) {
+	callback := make(chan []WorkerStatus)
+	handler.rosterChan <- &RosterRequest{Callback: callback}
+	ctx.JSON(http.StatusOK, <-callback)
+}
+
+// workerName identifies the worker that sent a request, by the id it sends or
+// by its address
+func workerName(ctx *gin.Context) string {
+	worker := ctx.GetHeader(workerIDHeader)
+	if worker == "" {
+		worker = ctx.ClientIP()
+	}
+	return worker
+}
+
+// A ValidatePatchRequest is a request to check a patch submitted by a worker.
+// If Error is set, the patch was already found to be invalid and is only
+// counted as a rejection. Baseline is set if the organism the patch was made
+// from isn't recent and has been rebuilt from the journal.
+type ValidatePatchRequest struct {
+	Patch    *Patch
+	Baseline *Organism
+	Worker   string
+	Address  string
+	Error    *PatchError
+	Callback chan<- *PatchError
+}
+
+// A RegisterWorkerRequest is a request to add a worker to the roster. The
+// callback receives its id.
+type RegisterWorkerRequest struct {
+	Registration *WorkerRegistration
+	Address      string
+	Callback     chan<- string
+}
+
+// A HeartbeatRequest is a request to record the progress of a worker. The
+// callback receives false if the worker isn't registered.
+type HeartbeatRequest struct {
+	Worker    string
+	Heartbeat *WorkerHeartbeat
+	Callback  chan<- bool
+}
+
+// A SubscribeRequest is a request to receive the hash of new top organisms
+type SubscribeRequest struct {
+	Callback chan<- chan string
+}
+
+// A RosterRequest is a request for the worker roster
+type RosterRequest struct {
+	Callback chan<- []WorkerStatus
 }
 
 // An UpdateRequest is a request to update the portal after an iteration,
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"context"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// shutdownContext returns a context that is cancelled when the process is
+// asked to stop with SIGINT or SIGTERM, so that it can save its progress
+// before exiting. A second signal exits immediately.
+func shutdownContext() (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(context.Background())
+	signals := make(chan os.Signal, 2)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		select {
+		case sig := <-signals:
+			log.Printf("Received %v, shutting down. Send it again to exit immediately.", sig)
+			cancel()
+		case <-ctx.Done():
+		}
+		sig := <-signals
+		log.Printf("Received %v, exiting without saving", sig)
+		os.Exit(1)
+	}()
+	return ctx, cancel
+}
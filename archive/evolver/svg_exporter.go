@@ -0,0 +1,73 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"log"
+)
+
+// An SVGExporter writes organisms as scalable vector graphics, so that
+// paintings can be printed at any size without re-rendering.
+type SVGExporter struct {
+	width        int
+	height       int
+	outputWidth  int
+	outputHeight int
+}
+
+// NewSVGExporter returns a new `SVGExporter` for a canvas of the given size
+func NewSVGExporter(width int, height int) *SVGExporter {
+	exporter := new(SVGExporter)
+	exporter.width = width
+	exporter.height = height
+	exporter.outputWidth = width
+	exporter.outputHeight = height
+	return exporter
+}
+
+// SetOutputSize sets the displayed size of the svg. The canvas is scaled to fit.
+func (exporter *SVGExporter) SetOutputSize(width int, height int) {
+	exporter.outputWidth = width
+	exporter.outputHeight = height
+}
+
+// Export writes the organism's instructions as an svg document. Instructions
+// are emitted in order so that later shapes are painted on top, matching
+// the Renderer.
+func (exporter *SVGExporter) Export(organism *Organism, writer io.Writer) error {
+	w := bufio.NewWriter(writer)
+	fmt.Fprintf(
+		w,
+		"<svg xmlns=\"http://www.w3.org/2000/svg\" width=\"%v\" height=\"%v\" viewBox=\"0 0 %v %v\" preserveAspectRatio=\"none\">\n",
+		exporter.outputWidth, exporter.outputHeight, exporter.width, exporter.height)
+	fmt.Fprintf(w, "<rect width=\"%v\" height=\"%v\" fill=\"%v\"/>\n", exporter.width, exporter.height, SaveColorHex(organism.Background))
+	for _, instruction := range organism.Instructions {
+		element := exporter.exportInstruction(instruction)
+		if element == "" {
+			continue
+		}
+		w.WriteString(element)
+		w.WriteString("\n")
+	}
+	w.WriteString("</svg>\n")
+	return w.Flush()
+}
+
+func (exporter *SVGExporter) exportInstruction(instruction Instruction) string {
+	instructionType, err := GetInstructionType(instruction.Type())
+	if err != nil || instructionType.ExportSVG == nil {
+		log.Printf("SVG export: skipping unsupported instruction type '%v'", instruction.Type())
+		return ""
+	}
+	return instructionType.ExportSVG(instruction)
+}
+
+// svgOpacityAttribute returns an opacity attribute for translucent instructions,
+// or nothing for opaque ones
+func svgOpacityAttribute(attribute string, opacity float32) string {
+	if opacity >= 1 {
+		return ""
+	}
+	return fmt.Sprintf(" %v=\"%v\"", attribute, opacity)
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"image"
+	"image/draw"
+)
+
+// A Tile is a part of a large target that is evolved on its own. Its
+// organism is scored against Bounds, which extends beyond the Core it paints
+// into its neighbors.
+type Tile struct {
+	Column int
+	Row    int
+	Bounds image.Rectangle
+	Core   image.Rectangle
+}
+
+// TileGrid divides an image into tiles with cores of at most size pixels.
+// The bounds of all tiles have the same size: the core plus overlap on each
+// side, shifted to stay inside the image. This lets every tile share the
+// renderers and diff maps of the object pool.
+func TileGrid(width int, height int, size int, overlap int) []Tile {
+	boundsWidth := minInt(width, size+2*overlap)
+	boundsHeight := minInt(height, size+2*overlap)
+	tiles := []Tile{}
+	for row := 0; row*size < height; row++ {
+		for column := 0; column*size < width; column++ {
+			core := image.Rect(column*size, row*size, minInt(width, (column+1)*size), minInt(height, (row+1)*size))
+			left := maxInt(0, minInt(core.Min.X-overlap, width-boundsWidth))
+			top := maxInt(0, minInt(core.Min.Y-overlap, height-boundsHeight))
+			tiles = append(tiles, Tile{
+				Column: column,
+				Row:    row,
+				Bounds: image.Rect(left, top, left+boundsWidth, top+boundsHeight),
+				Core:   core,
+			})
+		}
+	}
+	return tiles
+}
+
+// cropImage copies part of an image into a new image starting at 0, 0
+func cropImage(source image.Image, bounds image.Rectangle) *image.RGBA {
+	cropped := image.NewRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	draw.Draw(cropped, cropped.Bounds(), source, source.Bounds().Min.Add(bounds.Min), draw.Src)
+	return cropped
+}
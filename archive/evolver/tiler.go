@@ -0,0 +1,273 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"log"
+	"math"
+	"runtime"
+	"sync"
+)
+
+// A Tiler evolves a large target in overlapping tiles of equal size and
+// stitches the results into one organism. Only the tiles being evolved need
+// renderers and diff maps, so memory doesn't grow with the size of the
+// target. Finished tiles are saved to their own population files, so an
+// interrupted run continues with the remaining tiles.
+type Tiler struct {
+	config       *Config // Config of the tile incubators
+	refineConfig *Config // Config of the incubator refining the seams
+	target       image.Image
+	focus        image.Image
+	prefix       string // Tile population files are named after this
+	tiles        []Tile
+	overlap      int
+	concurrency  int
+	// All tiles share the background, so that they match where they leave it
+	// uncovered
+	background color.RGBA
+}
+
+// NewTiler returns a new `Tiler` with tiles that paint size pixels and are
+// scored against overlap pixels of their neighbors. focus is optional.
+func NewTiler(config *Config, target image.Image, focus image.Image, prefix string, size int, overlap int) *Tiler {
+	tiler := new(Tiler)
+	tiler.target = target
+	tiler.focus = focus
+	tiler.prefix = prefix
+	tiler.overlap = overlap
+	bounds := target.Bounds().Size()
+	tiler.tiles = TileGrid(bounds.X, bounds.Y, size, overlap)
+	tiler.concurrency = config.TileConcurrency
+	if tiler.concurrency <= 0 {
+		tiler.concurrency = runtime.NumCPU()
+	}
+	tileConfig := *config
+	tileConfig.BackgroundMutationRate = 0
+	tileConfig.CheckpointHistory = 0
+	tileConfig.WorkerCount = maxInt(1, runtime.NumCPU()/tiler.concurrency)
+	tiler.config = &tileConfig
+	refineConfig := *config
+	refineConfig.BackgroundMutationRate = 0
+	refineConfig.CheckpointHistory = 0
+	tiler.refineConfig = &refineConfig
+	tiler.background = AverageColor(target)
+	return tiler
+}
+
+// Tiles returns the tiles the target is divided into
+func (tiler *Tiler) Tiles() []Tile {
+	return tiler.tiles
+}
+
+// Filename returns the name of the population file of a finished tile
+func (tiler *Tiler) Filename(tile Tile) string {
+	return fmt.Sprintf("%v.tile.%03d.%03d.population.txt", tiler.prefix, tile.Column, tile.Row)
+}
+
+// Run evolves the unfinished tiles, several at a time, each until it reaches
+// a plateau. It returns once all tiles are finished, or when ctx is
+// cancelled, in which case the tiles in progress are discarded.
+func (tiler *Tiler) Run(ctx context.Context) {
+	size := tiler.tiles[0].Bounds.Size()
+	objectPool.SetRendererBounds(size.X, size.Y)
+	log.Printf("Evolving %v tiles of %vx%v, %v at a time", len(tiler.tiles), size.X, size.Y, tiler.concurrency)
+
+	pending := make(chan Tile)
+	wg := sync.WaitGroup{}
+	for i := 0; i < tiler.concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for tile := range pending {
+				tiler.evolveTile(ctx, tile)
+			}
+		}()
+	}
+	for _, tile := range tiler.tiles {
+		if CheckpointExists(tiler.Filename(tile)) {
+			log.Printf("Tile %v,%v is already finished", tile.Column, tile.Row)
+			continue
+		}
+		select {
+		case pending <- tile:
+		case <-ctx.Done():
+		}
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	close(pending)
+	wg.Wait()
+}
+
+func (tiler *Tiler) evolveTile(ctx context.Context, tile Tile) {
+	target := cropImage(tiler.target, tile.Bounds)
+	var focus image.Image
+	if tiler.focus != nil {
+		focus = cropImage(tiler.focus, tile.Bounds)
+	}
+	mutator := createMutator(tiler.config, target, focus)
+	incubator := NewIncubator(tiler.config, target, mutator, createRanker())
+	incubator.TargetName = tiler.prefix
+	tileCtx, stop := context.WithCancel(context.Background())
+	incubator.Start(tileCtx)
+	defer func() {
+		stop()
+		incubator.Dispose()
+	}()
+
+	seed := objectPool.BorrowOrganism()
+	seed.Background = tiler.background
+	incubator.SetTopOrganism(seed)
+	objectPool.ReturnOrganism(seed)
+
+	plateau := NewPlateauDetector(tiler.config.PlateauWindow, tiler.config.PlateauThreshold)
+	for {
+		if ctx.Err() != nil {
+			log.Printf("Discarding unfinished tile %v,%v", tile.Column, tile.Row)
+			return
+		}
+		incubator.Iterate()
+		topOrganism := incubator.GetTopOrganism()
+		diff := topOrganism.Diff
+		objectPool.ReturnOrganism(topOrganism)
+		if plateau.Record(diff) {
+			log.Printf("Tile %v,%v reached a plateau at diff %v after %v iterations", tile.Column, tile.Row, diff, incubator.Iteration)
+			break
+		}
+	}
+	incubator.Save(tiler.Filename(tile))
+}
+
+// Stitch combines the finished tiles into one organism for the whole target.
+// Each tile contributes the instructions centered in its core, so every area
+// is painted by the tile that was scored on its surroundings. Tiles at the
+// edges also contribute instructions centered outside of the target. The
+// number of unfinished tiles is returned as well. The organism is borrowed
+// from objectPool, which is prepared for the full size target.
+func (tiler *Tiler) Stitch() (*Organism, int) {
+	size := tiler.target.Bounds().Size()
+	objectPool.SetRendererBounds(size.X, size.Y)
+	organism := objectPool.BorrowOrganism()
+	organism.Background = tiler.background
+	unfinished := 0
+	for _, tile := range tiler.tiles {
+		if !CheckpointExists(tiler.Filename(tile)) {
+			unfinished++
+			continue
+		}
+		populationFile, _, err := LoadCheckpoint(tiler.Filename(tile))
+		if err != nil {
+			log.Printf("Error loading tile %v,%v: %v", tile.Column, tile.Row, err.Error())
+			unfinished++
+			continue
+		}
+		tileOrganism := populationFile.TopOrganism()
+		if tileOrganism == nil {
+			unfinished++
+			continue
+		}
+		core := tileCore(tile, size)
+		dx := float32(tile.Bounds.Min.X)
+		dy := float32(tile.Bounds.Min.Y)
+		for _, instruction := range tileOrganism.Instructions {
+			bounds := instruction.Bounds()
+			x := float64((bounds.Left+bounds.Right)/2 + dx)
+			y := float64((bounds.Top+bounds.Bottom)/2 + dy)
+			if x >= core[0] && x < core[2] && y >= core[1] && y < core[3] {
+				organism.Instructions = append(organism.Instructions, instruction.Translate(dx, dy))
+			}
+		}
+	}
+	return organism, unfinished
+}
+
+// Refine evolves the stitched organism against the whole target until it
+// reaches a plateau, with mutations focused on the seams between tiles. If
+// ctx is cancelled first, the organism refined so far is returned. The
+// returned organism is borrowed from objectPool. Unlike the tiles, this needs
+// memory for a population of the full size target.
+func (tiler *Tiler) Refine(ctx context.Context, organism *Organism) *Organism {
+	size := tiler.target.Bounds().Size()
+	objectPool.SetRendererBounds(size.X, size.Y)
+	log.Printf("Refining the seams between %v tiles at %vx%v", len(tiler.tiles), size.X, size.Y)
+	ranker := createRanker()
+	mutator := createMutator(tiler.refineConfig, tiler.target, tiler.seamFocus())
+	incubator := NewIncubator(tiler.refineConfig, tiler.target, mutator, ranker)
+	incubator.TargetName = tiler.prefix
+	refineCtx, stop := context.WithCancel(context.Background())
+	incubator.Start(refineCtx)
+	incubator.SetTopOrganism(organism)
+
+	plateau := NewPlateauDetector(tiler.refineConfig.PlateauWindow, tiler.refineConfig.PlateauThreshold)
+	for ctx.Err() == nil {
+		incubator.Iterate()
+		topOrganism := incubator.GetTopOrganism()
+		displayProgress(ranker, incubator.GetIncubatorStats(), topOrganism.Diff, topOrganism.RawDiff, len(topOrganism.Instructions))
+		diff := topOrganism.Diff
+		objectPool.ReturnOrganism(topOrganism)
+		if plateau.Record(diff) {
+			log.Printf("Seams reached a plateau at diff %v after %v iterations", diff, incubator.Iteration)
+			break
+		}
+	}
+	refined := incubator.GetTopOrganism()
+	stop()
+	incubator.Dispose()
+	return refined
+}
+
+// seamFocus returns a focus map of the target that covers the overlap around
+// each seam between tile cores. Within it, the focus map of the tiler applies
+// if there is one.
+func (tiler *Tiler) seamFocus() image.Image {
+	size := tiler.target.Bounds().Size()
+	focus := image.NewGray(image.Rect(0, 0, size.X, size.Y))
+	width := maxInt(1, tiler.overlap)
+	for _, tile := range tiler.tiles {
+		// Each tile marks the seams at the right and bottom of its core
+		core := tile.Core
+		if core.Max.X < size.X {
+			seam := image.Rect(core.Max.X-width, core.Min.Y, core.Max.X+width, core.Max.Y)
+			draw.Draw(focus, seam.Intersect(focus.Bounds()), image.White, image.Point{}, draw.Src)
+		}
+		if core.Max.Y < size.Y {
+			seam := image.Rect(core.Min.X, core.Max.Y-width, core.Max.X, core.Max.Y+width)
+			draw.Draw(focus, seam.Intersect(focus.Bounds()), image.White, image.Point{}, draw.Src)
+		}
+	}
+	if tiler.focus != nil {
+		origin := tiler.focus.Bounds().Min
+		for y := 0; y < size.Y; y++ {
+			for x := 0; x < size.X; x++ {
+				if focus.GrayAt(x, y).Y > 0 {
+					focus.Set(x, y, tiler.focus.At(origin.X+x, origin.Y+y))
+				}
+			}
+		}
+	}
+	return focus
+}
+
+// tileCore returns the left, top, right and bottom of the area a tile
+// paints, extended to infinity at the edges of the target
+func tileCore(tile Tile, size image.Point) [4]float64 {
+	core := [4]float64{float64(tile.Core.Min.X), float64(tile.Core.Min.Y), float64(tile.Core.Max.X), float64(tile.Core.Max.Y)}
+	if tile.Core.Min.X == 0 {
+		core[0] = math.Inf(-1)
+	}
+	if tile.Core.Min.Y == 0 {
+		core[1] = math.Inf(-1)
+	}
+	if tile.Core.Max.X == size.X {
+		core[2] = math.Inf(1)
+	}
+	if tile.Core.Max.Y == size.Y {
+		core[3] = math.Inf(1)
+	}
+	return core
+}
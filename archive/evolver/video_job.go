@@ -0,0 +1,136 @@
+package main
+
+import (
+	"crypto/md5"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+const (
+	// VideoJobStatusWaiting - job created, waiting for a video upload
+	VideoJobStatusWaiting = "waiting for video"
+	// VideoJobStatusSplitting - video is being split into frames
+	VideoJobStatusSplitting = "splitting video"
+	// VideoJobStatusRunning - frames are being handed out to workers
+	VideoJobStatusRunning = "running"
+	// VideoJobStatusCompleted - all frames have been painted
+	VideoJobStatusCompleted = "completed"
+	// VideoJobStatusError - something went wrong processing the video
+	VideoJobStatusError = "error"
+)
+
+// VideoJobConfiguration describes how a video job should be rendered.
+// Field names match the json sent by the browser client.
+type VideoJobConfiguration struct {
+	ResolutionX int     `json:"resolutionX"`
+	ResolutionY int     `json:"resolutionY"`
+	OutputFPS   int     `json:"outputFPS"`
+	Duration    float32 `json:"duration"` // Minutes of evolution per frame
+}
+
+// Defaults for jobs that were saved without a configuration, or with parts of
+// it missing
+const (
+	defaultVideoJobOutputFPS = 30
+	defaultVideoJobDuration  = 1
+)
+
+// ParseVideoJobConfiguration parses the json configuration sent by the client
+func ParseVideoJobConfiguration(data string) (*VideoJobConfiguration, error) {
+	configuration := &VideoJobConfiguration{}
+	err := json.Unmarshal([]byte(data), configuration)
+	if err != nil {
+		return nil, err
+	}
+	if configuration.ResolutionX <= 0 || configuration.ResolutionY <= 0 {
+		return nil, fmt.Errorf("Invalid resolution %vx%v", configuration.ResolutionX, configuration.ResolutionY)
+	}
+	if configuration.OutputFPS <= 0 {
+		return nil, fmt.Errorf("Invalid output fps %v", configuration.OutputFPS)
+	}
+	return configuration, nil
+}
+
+// A VideoJob is a video that is painted frame by frame by browser workers.
+type VideoJob struct {
+	ID            string
+	Name          string
+	Status        string
+	Configuration *VideoJobConfiguration
+	WorkItems     []*VideoWorkItem
+	Created       time.Time
+}
+
+// NewVideoJob returns a new `VideoJob` waiting for its video upload
+func NewVideoJob(name string, configuration *VideoJobConfiguration) *VideoJob {
+	job := new(VideoJob)
+	job.Name = name
+	job.Configuration = configuration
+	job.Status = VideoJobStatusWaiting
+	job.Created = time.Now()
+	job.ID = fmt.Sprintf("%x", md5.Sum([]byte(fmt.Sprintf("%v|%v", name, job.Created.UnixNano()))))
+	job.WorkItems = []*VideoWorkItem{}
+	return job
+}
+
+// ApplyDefaults fills in the configuration of a job that was saved before it
+// was configurable, or with missing settings
+func (job *VideoJob) ApplyDefaults() {
+	if job.Configuration == nil {
+		job.Configuration = &VideoJobConfiguration{}
+	}
+	if job.Configuration.OutputFPS <= 0 {
+		job.Configuration.OutputFPS = defaultVideoJobOutputFPS
+	}
+	if job.Configuration.Duration <= 0 {
+		job.Configuration.Duration = defaultVideoJobDuration
+	}
+	if job.WorkItems == nil {
+		job.WorkItems = []*VideoWorkItem{}
+	}
+}
+
+// CompletedWorkItems returns the number of frames that have been painted
+func (job *VideoJob) CompletedWorkItems() int {
+	count := 0
+	for _, workItem := range job.WorkItems {
+		if workItem.Completed {
+			count++
+		}
+	}
+	return count
+}
+
+// NextWorkItem returns the next frame that isn't completed or checked out
+// by another worker. Checkouts expire after `lease` so that frames abandoned
+// by closed browser tabs are eventually handed out again.
+func (job *VideoJob) NextWorkItem(lease time.Duration) *VideoWorkItem {
+	for _, workItem := range job.WorkItems {
+		if workItem.Completed {
+			continue
+		}
+		if workItem.CheckedOut.IsZero() || time.Since(workItem.CheckedOut) > lease {
+			return workItem
+		}
+	}
+	return nil
+}
+
+// GetWorkItem returns the work item with the specified id, or nil.
+func (job *VideoJob) GetWorkItem(id string) *VideoWorkItem {
+	for _, workItem := range job.WorkItems {
+		if workItem.ID == id {
+			return workItem
+		}
+	}
+	return nil
+}
+
+// A VideoWorkItem is a single frame of a video job
+type VideoWorkItem struct {
+	ID         string
+	Frame      int
+	Completed  bool
+	CheckedOut time.Time
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+
+	graphql "github.com/graph-gophers/graphql-go"
+)
+
+// videoSchema is the GraphQL schema expected by the browser video client
+// (evolver-webgl-ts/src/server/server.ts). Configurations are passed around
+// as json strings.
+const videoSchema = `
+schema {
+	query: Query
+	mutation: Mutation
+}
+
+type Query {
+	hello: String!
+	videojobs: [VideoJob!]!
+}
+
+type Mutation {
+	createVideoJob(name: String!, configuration: String!): VideoJob!
+	deleteVideoJob(id: ID!): Boolean!
+	getVideoWorkItem: WorkItem
+	submitVideoWorkItemResult(jobId: ID!, workItemId: ID!, imageData: String!, brushStrokes: String!): Boolean!
+}
+
+type VideoJob {
+	id: ID!
+	name: String!
+	status: String!
+	workItems: Int!
+	completedWorkItems: Int!
+	configuration: String!
+}
+
+type WorkItem {
+	id: ID!
+	jobId: ID!
+	imageData: String!
+	configuration: String!
+}
+`
+
+// videoResolver resolves the root Query and Mutation types of videoSchema
+type videoResolver struct {
+	server *VideoServer
+}
+
+func (r *videoResolver) Hello() string {
+	return "Hello from evolver"
+}
+
+func (r *videoResolver) Videojobs() []*videoJobResolver {
+	resolvers := []*videoJobResolver{}
+	for _, job := range r.server.ListJobs() {
+		resolvers = append(resolvers, &videoJobResolver{job: job})
+	}
+	return resolvers
+}
+
+func (r *videoResolver) CreateVideoJob(args struct {
+	Name          string
+	Configuration string
+}) (*videoJobResolver, error) {
+	configuration, err := ParseVideoJobConfiguration(args.Configuration)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid configuration: %v", err.Error())
+	}
+	job, err := r.server.CreateJob(args.Name, configuration)
+	if err != nil {
+		return nil, err
+	}
+	return &videoJobResolver{job: job}, nil
+}
+
+func (r *videoResolver) DeleteVideoJob(args struct{ ID graphql.ID }) bool {
+	return r.server.DeleteJob(string(args.ID))
+}
+
+func (r *videoResolver) GetVideoWorkItem() (*videoWorkItemResolver, error) {
+	workItem, job := r.server.CheckoutWorkItem()
+	if workItem == nil {
+		return nil, nil
+	}
+	data, err := r.server.GetFrameData(job.ID, workItem)
+	if err != nil {
+		return nil, err
+	}
+	return &videoWorkItemResolver{
+		workItem:  workItem,
+		job:       job,
+		imageData: base64.StdEncoding.EncodeToString(data),
+	}, nil
+}
+
+func (r *videoResolver) SubmitVideoWorkItemResult(args struct {
+	JobID        graphql.ID
+	WorkItemID   graphql.ID
+	ImageData    string
+	BrushStrokes string
+}) (bool, error) {
+	err := r.server.SubmitWorkItemResult(string(args.JobID), string(args.WorkItemID), args.ImageData, args.BrushStrokes)
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+type videoJobResolver struct {
+	job VideoJob
+}
+
+func (r *videoJobResolver) ID() graphql.ID {
+	return graphql.ID(r.job.ID)
+}
+
+func (r *videoJobResolver) Name() string {
+	return r.job.Name
+}
+
+func (r *videoJobResolver) Status() string {
+	return r.job.Status
+}
+
+func (r *videoJobResolver) WorkItems() int32 {
+	return int32(len(r.job.WorkItems))
+}
+
+func (r *videoJobResolver) CompletedWorkItems() int32 {
+	return int32(r.job.CompletedWorkItems())
+}
+
+func (r *videoJobResolver) Configuration() string {
+	data, _ := json.Marshal(r.job.Configuration)
+	return string(data)
+}
+
+type videoWorkItemResolver struct {
+	workItem  *VideoWorkItem
+	job       *VideoJob
+	imageData string
+}
+
+func (r *videoWorkItemResolver) ID() graphql.ID {
+	return graphql.ID(r.workItem.ID)
+}
+
+func (r *videoWorkItemResolver) JobID() graphql.ID {
+	return graphql.ID(r.job.ID)
+}
+
+func (r *videoWorkItemResolver) ImageData() string {
+	return r.imageData
+}
+
+func (r *videoWorkItemResolver) Configuration() string {
+	data, _ := json.Marshal(r.job.Configuration)
+	return string(data)
+}
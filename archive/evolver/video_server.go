@@ -0,0 +1,425 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	graphql "github.com/graph-gophers/graphql-go"
+	"github.com/graph-gophers/graphql-go/relay"
+)
+
+// videoWorkItemGracePeriod is added to the configured frame duration before
+// a checked out work item is handed out to another worker.
+const videoWorkItemGracePeriod = time.Minute * 10
+
+// VideoServer splits uploaded videos into frames and hands them out to
+// browser workers, collecting the painted frames as they are submitted.
+// All job state is owned by a single background goroutine.
+type VideoServer struct {
+	folder string
+	jobs   map[string]*VideoJob
+
+	// communication channels
+	requestChan chan *VideoServerRequest
+}
+
+// VideoServerRequest is a request to run an action against the job state
+// on the background goroutine.
+type VideoServerRequest struct {
+	Action   func()
+	Callback VoidCallback
+}
+
+// NewVideoServer returns a new VideoServer that stores jobs in `folder`
+func NewVideoServer(folder string) *VideoServer {
+	server := new(VideoServer)
+	server.folder = folder
+	server.jobs = map[string]*VideoJob{}
+	server.requestChan = make(chan *VideoServerRequest)
+	return server
+}
+
+// Start loads existing jobs from disk and begins listening for requests.
+func (server *VideoServer) Start(address string) error {
+	err := os.MkdirAll(server.folder, 0755)
+	if err != nil {
+		return err
+	}
+	server.loadJobs()
+	server.startBackgroundRoutine()
+
+	r := gin.New()
+	r.Use(gin.Recovery())
+	r.Use(allowCrossOrigin)
+	schema := graphql.MustParseSchema(videoSchema, &videoResolver{server: server})
+	r.GET("/", func(ctx *gin.Context) {
+		ctx.Data(http.StatusOK, "text/plain", []byte("Service is up!"))
+	})
+	r.POST("/query", gin.WrapH(&relay.Handler{Schema: schema}))
+	r.POST("/upload-video", server.UploadVideo)
+	log.Printf("Video server listening on %v", address)
+	return http.ListenAndServe(address, r)
+}
+
+// allowCrossOrigin lets the browser client (served from a different origin)
+// talk to the video server.
+func allowCrossOrigin(ctx *gin.Context) {
+	ctx.Header("Access-Control-Allow-Origin", "*")
+	ctx.Header("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
+	ctx.Header("Access-Control-Allow-Headers", "Content-Type")
+	if ctx.Request.Method == http.MethodOptions {
+		ctx.AbortWithStatus(http.StatusNoContent)
+		return
+	}
+	ctx.Next()
+}
+
+func (server *VideoServer) startBackgroundRoutine() {
+	go func() {
+		for req := range server.requestChan {
+			req.Action()
+			req.Callback <- nil
+		}
+	}()
+}
+
+// do runs an action on the background goroutine and waits for it to finish
+func (server *VideoServer) do(action func()) {
+	callback := make(chan error)
+	server.requestChan <- &VideoServerRequest{
+		Action:   action,
+		Callback: callback,
+	}
+	<-callback
+}
+
+func (server *VideoServer) loadJobs() {
+	files, err := filepath.Glob(filepath.Join(server.folder, "*", "job.json"))
+	if err != nil {
+		log.Printf("Error listing video jobs: '%v'", err.Error())
+		return
+	}
+	for _, filename := range files {
+		data, err := ioutil.ReadFile(filename)
+		if err != nil {
+			log.Printf("Error reading video job '%v': '%v'", filename, err.Error())
+			continue
+		}
+		job := &VideoJob{}
+		err = json.Unmarshal(data, job)
+		if err != nil {
+			log.Printf("Error parsing video job '%v': '%v'", filename, err.Error())
+			continue
+		}
+		job.ApplyDefaults()
+		// A split that was interrupted by a restart won't finish on its own
+		if job.Status == VideoJobStatusSplitting {
+			job.Status = VideoJobStatusError
+		}
+		server.jobs[job.ID] = job
+	}
+	log.Printf("Loaded %v video jobs", len(server.jobs))
+}
+
+func (server *VideoServer) saveJob(job *VideoJob) {
+	data, _ := json.MarshalIndent(job, "", "    ")
+	// Write a temporary file first, so a crash can't leave a partial job file
+	filename := filepath.Join(server.jobFolder(job.ID), "job.json")
+	tempFilename := filename + ".tmp"
+	err := ioutil.WriteFile(tempFilename, data, 0644)
+	if err == nil {
+		err = os.Rename(tempFilename, filename)
+	}
+	if err != nil {
+		os.Remove(tempFilename)
+		log.Printf("Error saving video job '%v': '%v'", job.ID, err.Error())
+	}
+}
+
+func (server *VideoServer) jobFolder(jobID string) string {
+	return filepath.Join(server.folder, jobID)
+}
+
+func (server *VideoServer) framesFolder(jobID string) string {
+	return filepath.Join(server.jobFolder(jobID), "frames")
+}
+
+func (server *VideoServer) resultsFolder(jobID string) string {
+	return filepath.Join(server.jobFolder(jobID), "results")
+}
+
+// CreateJob creates a new job that is waiting for a video upload
+func (server *VideoServer) CreateJob(name string, configuration *VideoJobConfiguration) (VideoJob, error) {
+	job := NewVideoJob(name, configuration)
+	err := os.MkdirAll(server.jobFolder(job.ID), 0755)
+	if err != nil {
+		return VideoJob{}, err
+	}
+	server.do(func() {
+		server.jobs[job.ID] = job
+		server.saveJob(job)
+	})
+	log.Printf("Created video job '%v' (%v)", job.Name, job.ID)
+	return *job, nil
+}
+
+// DeleteJob removes a job and all of its files
+func (server *VideoServer) DeleteJob(id string) bool {
+	found := false
+	server.do(func() {
+		_, found = server.jobs[id]
+		delete(server.jobs, id)
+	})
+	if found {
+		err := os.RemoveAll(server.jobFolder(id))
+		if err != nil {
+			log.Printf("Error removing video job folder '%v': '%v'", id, err.Error())
+		}
+		log.Printf("Deleted video job %v", id)
+	}
+	return found
+}
+
+// ListJobs returns a snapshot of all jobs
+func (server *VideoServer) ListJobs() []VideoJob {
+	jobs := []VideoJob{}
+	server.do(func() {
+		for _, job := range server.jobs {
+			snapshot := *job
+			snapshot.WorkItems = nil
+			for _, workItem := range job.WorkItems {
+				item := *workItem
+				snapshot.WorkItems = append(snapshot.WorkItems, &item)
+			}
+			jobs = append(jobs, snapshot)
+		}
+	})
+	sort.Slice(jobs, func(i, j int) bool { return jobs[i].Created.Before(jobs[j].Created) })
+	return jobs
+}
+
+// CheckoutWorkItem returns the next frame that needs painting along with its
+// job, or nil if there is nothing to do.
+func (server *VideoServer) CheckoutWorkItem() (*VideoWorkItem, *VideoJob) {
+	var workItem *VideoWorkItem
+	var job *VideoJob
+	server.do(func() {
+		for _, candidate := range server.orderedJobs() {
+			if candidate.Status != VideoJobStatusRunning {
+				continue
+			}
+			lease := time.Duration(float64(candidate.Configuration.Duration)*float64(time.Minute)) + videoWorkItemGracePeriod
+			next := candidate.NextWorkItem(lease)
+			if next != nil {
+				next.CheckedOut = time.Now()
+				item := *next
+				snapshot := *candidate
+				workItem, job = &item, &snapshot
+				return
+			}
+		}
+	})
+	return workItem, job
+}
+
+// orderedJobs returns jobs oldest first so that earlier jobs finish first
+func (server *VideoServer) orderedJobs() []*VideoJob {
+	jobs := make([]*VideoJob, 0, len(server.jobs))
+	for _, job := range server.jobs {
+		jobs = append(jobs, job)
+	}
+	sort.Slice(jobs, func(i, j int) bool { return jobs[i].Created.Before(jobs[j].Created) })
+	return jobs
+}
+
+// GetFrameData returns the jpeg data of a source frame
+func (server *VideoServer) GetFrameData(jobID string, workItem *VideoWorkItem) ([]byte, error) {
+	return ioutil.ReadFile(filepath.Join(server.framesFolder(jobID), fmt.Sprintf("%05d.jpg", workItem.Frame)))
+}
+
+// SubmitWorkItemResult records a painted frame. `imageData` is a base64 jpeg
+// and `brushStrokes` is stored as-is next to it.
+func (server *VideoServer) SubmitWorkItemResult(jobID string, workItemID string, imageData string, brushStrokes string) error {
+	data, err := base64.StdEncoding.DecodeString(imageData)
+	if err != nil {
+		return fmt.Errorf("Invalid image data: %v", err.Error())
+	}
+	var workItem *VideoWorkItem
+	server.do(func() {
+		job, has := server.jobs[jobID]
+		if has {
+			workItem = job.GetWorkItem(workItemID)
+		}
+	})
+	if workItem == nil {
+		return fmt.Errorf("Work item %v not found for job %v", workItemID, jobID)
+	}
+	resultsFolder := server.resultsFolder(jobID)
+	err = os.MkdirAll(resultsFolder, 0755)
+	if err != nil {
+		return err
+	}
+	err = ioutil.WriteFile(filepath.Join(resultsFolder, fmt.Sprintf("%05d.jpg", workItem.Frame)), data, 0644)
+	if err != nil {
+		return err
+	}
+	if brushStrokes != "" {
+		err = ioutil.WriteFile(filepath.Join(resultsFolder, fmt.Sprintf("%05d.strokes.json", workItem.Frame)), []byte(brushStrokes), 0644)
+		if err != nil {
+			return err
+		}
+	}
+
+	var completedJob *VideoJob
+	server.do(func() {
+		job, has := server.jobs[jobID]
+		if !has || job.GetWorkItem(workItemID) == nil {
+			return
+		}
+		job.GetWorkItem(workItemID).Completed = true
+		if job.Status == VideoJobStatusRunning && job.CompletedWorkItems() == len(job.WorkItems) {
+			job.Status = VideoJobStatusCompleted
+			snapshot := *job
+			completedJob = &snapshot
+		}
+		server.saveJob(job)
+	})
+	log.Printf("Video job %v: frame %v completed", jobID, workItem.Frame)
+	if completedJob != nil {
+		go server.encodeResults(completedJob)
+	}
+	return nil
+}
+
+// UploadVideo accepts a multipart upload with `jobId` and `video` fields and
+// splits the video into frames in the background.
+func (server *VideoServer) UploadVideo(ctx *gin.Context) {
+	jobID := ctx.PostForm("jobId")
+	file, err := ctx.FormFile("video")
+	if err != nil {
+		ctx.AbortWithError(http.StatusBadRequest, err)
+		return
+	}
+	var job *VideoJob
+	server.do(func() {
+		candidate, has := server.jobs[jobID]
+		if has && candidate.Status == VideoJobStatusWaiting {
+			candidate.Status = VideoJobStatusSplitting
+			server.saveJob(candidate)
+			job = candidate
+		}
+	})
+	if job == nil {
+		ctx.JSON(http.StatusNotFound, map[string]interface{}{"Message": "Job not found or video already uploaded"})
+		return
+	}
+	videoFilename := filepath.Join(server.jobFolder(jobID), "source"+strings.ToLower(filepath.Ext(file.Filename)))
+	err = ctx.SaveUploadedFile(file, videoFilename)
+	if err != nil {
+		server.setJobStatus(jobID, VideoJobStatusError)
+		ctx.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+	log.Printf("Video job %v: received '%v'", jobID, file.Filename)
+	go server.splitVideo(jobID, videoFilename, job.Configuration.OutputFPS)
+	ctx.JSON(http.StatusOK, map[string]interface{}{"Message": "Video uploaded"})
+}
+
+func (server *VideoServer) setJobStatus(jobID string, status string) {
+	server.do(func() {
+		job, has := server.jobs[jobID]
+		if has {
+			job.Status = status
+			server.saveJob(job)
+		}
+	})
+}
+
+// splitVideo extracts the frames of a video with ffmpeg and queues up a
+// work item for each of them.
+func (server *VideoServer) splitVideo(jobID string, videoFilename string, fps int) {
+	framesFolder := server.framesFolder(jobID)
+	os.RemoveAll(framesFolder)
+	err := os.MkdirAll(framesFolder, 0755)
+	if err != nil {
+		log.Printf("Video job %v: error creating frames folder: '%v'", jobID, err.Error())
+		server.setJobStatus(jobID, VideoJobStatusError)
+		return
+	}
+	// ffmpeg -i source.mp4 -vf fps=30 frames/%05d.jpg
+	ffmpeg := exec.Command(
+		"ffmpeg",
+		"-y",
+		"-i",
+		videoFilename,
+		"-vf",
+		fmt.Sprintf("fps=%v", fps),
+		"-q:v",
+		"2",
+		filepath.Join(framesFolder, "%05d.jpg"),
+	)
+	ffmpeg.Stderr = os.Stderr
+	log.Printf("Video job %v: splitting video into frames...", jobID)
+	err = ffmpeg.Run()
+	if err != nil {
+		log.Printf("Video job %v: error splitting video: '%v'", jobID, err.Error())
+		server.setJobStatus(jobID, VideoJobStatusError)
+		return
+	}
+	frames, err := filepath.Glob(filepath.Join(framesFolder, "*.jpg"))
+	if err != nil || len(frames) == 0 {
+		log.Printf("Video job %v: no frames extracted", jobID)
+		server.setJobStatus(jobID, VideoJobStatusError)
+		return
+	}
+	server.do(func() {
+		job, has := server.jobs[jobID]
+		if !has {
+			return
+		}
+		job.WorkItems = job.WorkItems[:0]
+		// ffmpeg numbers frames starting at 1
+		for frame := 1; frame <= len(frames); frame++ {
+			job.WorkItems = append(job.WorkItems, &VideoWorkItem{
+				ID:    fmt.Sprintf("%05d", frame),
+				Frame: frame,
+			})
+		}
+		job.Status = VideoJobStatusRunning
+		server.saveJob(job)
+	})
+	log.Printf("Video job %v: %v frames ready", jobID, len(frames))
+}
+
+// encodeResults stitches the painted frames of a completed job back into a video.
+func (server *VideoServer) encodeResults(job *VideoJob) {
+	outfile := filepath.Join(server.jobFolder(job.ID), "output.mp4")
+	ffmpeg := exec.Command(
+		"ffmpeg",
+		"-y",
+		"-framerate",
+		fmt.Sprint(job.Configuration.OutputFPS),
+		"-i",
+		filepath.Join(server.resultsFolder(job.ID), "%05d.jpg"),
+		outfile,
+	)
+	ffmpeg.Stderr = os.Stderr
+	log.Printf("Video job %v: encoding '%v'", job.ID, outfile)
+	err := ffmpeg.Run()
+	if err != nil {
+		log.Printf("Video job %v: error encoding video: '%v'", job.ID, err.Error())
+		return
+	}
+	log.Printf("Video job %v: '%v' completed", job.ID, outfile)
+}
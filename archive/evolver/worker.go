@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"log"
 	"runtime"
 )
@@ -10,8 +11,9 @@ import (
 // A WorkItemResult is a submitted ranking for an organism. These
 // are returned from external worker processes.
 type WorkItemResult struct {
-	ID   string
-	Diff float32
+	ID      string
+	Diff    float32 // Fitness of the organism, including the complexity penalty
+	RawDiff float32 // Difference between the rendered organism and the target
 }
 
 // A Worker allows the evolver system to run logic on multiple CPU cores effectively.
@@ -67,10 +69,13 @@ func NewWorker(
 	return worker
 }
 
-func (worker *Worker) Start() {
+// Start runs the worker until the context is cancelled
+func (worker *Worker) Start(ctx context.Context) {
 	go func() {
 		for {
 			select {
+			case <-ctx.Done():
+				return
 			case organism := <-worker.rankChan:
 				// log.Printf(
 				// 	"Worker %v processing organism %p, diffmap=%p, diff=%v, diffmap-avg=%v",
@@ -82,9 +87,9 @@ func (worker *Worker) Start() {
 				// rendering and comparison if the organism has a parent.
 
 				if organism.Parent == nil || len(organism.AffectedAreas) == 0 {
-					renderer.Render(organism.Instructions)
+					renderer.Render(organism)
 				} else {
-					renderer.RenderBounds(organism.Instructions, organism.AffectedAreas)
+					renderer.RenderBounds(organism, worker.ranker.RenderAreas(organism.AffectedAreas, worker.imageWidth, worker.imageHeight))
 				}
 
 				renderedOrganism := renderer.GetImage()
@@ -116,8 +121,9 @@ func (worker *Worker) Start() {
 				}
 				objectPool.ReturnRenderer(renderer)
 				workItemResult := WorkItemResult{
-					ID:   organism.Hash(),
-					Diff: diff,
+					ID:      organism.Hash(),
+					Diff:    worker.ranker.Fitness(diff, len(organism.Instructions)),
+					RawDiff: diff,
 				}
 				worker.rankResultChan <- workItemResult
 			case organism := <-worker.saveChan:
@@ -196,7 +202,8 @@ func NewWorkerPool(
 	return pool
 }
 
-func (pool *WorkerPool) Start() {
+// Start runs the workers until the context is cancelled
+func (pool *WorkerPool) Start(ctx context.Context) {
 	numWorkers := pool.numWorkers
 	if numWorkers <= 0 {
 		numWorkers = runtime.NumCPU()
@@ -218,6 +225,6 @@ func (pool *WorkerPool) Start() {
 			pool.saveResultChan,
 			pool.loadChan,
 			pool.loadResultChan,
-		).Start()
+		).Start(ctx)
 	}
 }
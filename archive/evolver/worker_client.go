@@ -1,31 +1,137 @@
 package main
 
 import (
+	"bufio"
 	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
 	json "encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
 )
 
+// workerIDHeader identifies the worker process that sent a request
+const workerIDHeader = "X-Evolver-Worker"
+
+// ErrUnknownWorker is returned from heartbeats when the server doesn't know
+// the worker, so that it can register again
+var ErrUnknownWorker = errors.New("Worker is not registered with the server")
+
+// ErrStreamUnsupported is returned when the server can't stream top organism
+// updates, so that the worker has to poll for them
+var ErrStreamUnsupported = errors.New("Server does not stream updates")
+
+// streamMaxEventSize is the largest event accepted from update streams
+const streamMaxEventSize = 64 * 1024 * 1024
+
+// A StreamEvent is a server-sent event from the top organism stream
+type StreamEvent struct {
+	Name string
+	Data []byte
+}
+
 // WorkerClient is a client to access the http api of the main server.
 type WorkerClient struct {
-	endpoint string
+	endpoint   string
+	authSecret string
+	httpClient *http.Client
+	// workerID can change when registering, while the update stream is open
+	workerID      string
+	workerIDMutex sync.Mutex
 }
 
-func NewWorkerClient(endpoint string) *WorkerClient {
+// NewWorkerClient returns a new WorkerClient. If authSecret is set, every
+// request is signed with it. If caFile is set, server certificates signed by
+// the certificate authority in that file are trusted in addition to the
+// system roots.
+func NewWorkerClient(endpoint string, authSecret string, caFile string) (*WorkerClient, error) {
 	client := new(WorkerClient)
 	client.endpoint = endpoint
-	return client
+	hostname, _ := os.Hostname()
+	client.workerID = fmt.Sprintf("%v-%v", hostname, os.Getpid())
+	client.authSecret = authSecret
+	client.httpClient = http.DefaultClient
+	if caFile != "" {
+		rootCAs, err := loadCertPool(caFile)
+		if err != nil {
+			return nil, err
+		}
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.TLSClientConfig = &tls.Config{RootCAs: rootCAs}
+		client.httpClient = &http.Client{Transport: transport}
+	}
+	return client, nil
 }
 
-func (client *WorkerClient) GetTopOrganism() (*Organism, error) {
-	resp, err := http.Get(fmt.Sprintf("%v/organism", client.endpoint))
+// Register registers the worker with the server. Requests are identified by
+// the issued id from then on. It returns how often the server expects
+// heartbeats.
+func (client *WorkerClient) Register(cpus int) (time.Duration, error) {
+	hostname, _ := os.Hostname()
+	data, err := json.Marshal(&WorkerRegistration{
+		Hostname: hostname,
+		CPUs:     cpus,
+	})
 	if err != nil {
-		return nil, err
+		return 0, err
+	}
+	resp, err := client.do("POST", "/workers", data)
+	if err != nil {
+		return 0, err
 	}
 	defer resp.Body.Close()
-	data, err := ioutil.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("Received status code %v from server", resp.StatusCode)
+	}
+	registered := &WorkerRegistered{}
+	err = json.NewDecoder(resp.Body).Decode(registered)
+	if err != nil {
+		return 0, err
+	}
+	client.workerIDMutex.Lock()
+	client.workerID = registered.ID
+	client.workerIDMutex.Unlock()
+	return time.Duration(registered.HeartbeatSeconds) * time.Second, nil
+}
+
+// Heartbeat reports the progress of the worker to the server
+func (client *WorkerClient) Heartbeat(heartbeat *WorkerHeartbeat) error {
+	data, err := json.Marshal(heartbeat)
+	if err != nil {
+		return err
+	}
+	resp, err := client.do("POST", fmt.Sprintf("/workers/%v/heartbeat", url.PathEscape(client.WorkerID())), data)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return ErrUnknownWorker
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("Received status code %v from server", resp.StatusCode)
+	}
+	return nil
+}
+
+// WorkerID returns the id the worker identifies itself with
+func (client *WorkerClient) WorkerID() string {
+	client.workerIDMutex.Lock()
+	defer client.workerIDMutex.Unlock()
+	return client.workerID
+}
+
+func (client *WorkerClient) GetTopOrganism() (*Organism, error) {
+	data, err := client.get("/organism")
 	if err != nil {
 		return nil, err
 	}
@@ -35,15 +141,7 @@ func (client *WorkerClient) GetTopOrganism() (*Organism, error) {
 }
 
 func (client *WorkerClient) GetTopOrganismDelta(previous string) (*Patch, error) {
-	resp, err := http.Get(fmt.Sprintf("%v/organism/delta?previous=%v", client.endpoint, previous))
-	if err != nil {
-		return nil, err
-	}
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("Received status code %v from server", resp.StatusCode)
-	}
-	defer resp.Body.Close()
-	data, err := ioutil.ReadAll(resp.Body)
+	data, err := client.get(fmt.Sprintf("/organism/delta?previous=%v", previous))
 	if err != nil {
 		return nil, err
 	}
@@ -61,39 +159,155 @@ func (client *WorkerClient) SubmitOrganism(patch *Patch) error {
 	if err != nil {
 		return err
 	}
-	resp, err := http.Post(fmt.Sprintf("%v/organism", client.endpoint), "application/json", bytes.NewReader(data))
-	if resp != nil {
-		resp.Body.Close()
+	resp, err := client.do("POST", "/organism", data)
+	if err != nil {
+		return err
 	}
-	return err
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		patchErr := &PatchError{}
+		body, _ := ioutil.ReadAll(resp.Body)
+		if json.Unmarshal(body, patchErr) == nil && patchErr.Code != "" {
+			patchErr.Status = resp.StatusCode
+			return patchErr
+		}
+		return fmt.Errorf("Received status code %v from server", resp.StatusCode)
+	}
+	return nil
 }
 
-func (client *WorkerClient) GetTargetImageData() ([]byte, error) {
-	resp, err := http.Get(fmt.Sprintf("%v/target", client.endpoint))
+// StreamTopOrganism sends the events of the top organism stream to events
+// until the stream ends or the context is cancelled. See
+// ServerPortal.StreamTopOrganism for the events.
+func (client *WorkerClient) StreamTopOrganism(ctx context.Context, events chan<- *StreamEvent) error {
+	req, err := client.newRequest("GET", "/organism/stream", nil)
 	if err != nil {
-		return nil, err
+		return err
 	}
-	defer resp.Body.Close()
-	data, err := ioutil.ReadAll(resp.Body)
+	req.Header.Set("Accept", "text/event-stream")
+	resp, err := client.httpClient.Do(req.WithContext(ctx))
 	if err != nil {
-		return nil, err
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return ErrStreamUnsupported
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("Received status code %v from server", resp.StatusCode)
+	}
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 64*1024), streamMaxEventSize)
+	event := &StreamEvent{}
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" {
+			// A blank line ends the event
+			if event.Name != "" {
+				select {
+				case events <- event:
+				case <-ctx.Done():
+					return ctx.Err()
+				}
+			}
+			event = &StreamEvent{}
+			continue
+		}
+		parts := strings.SplitN(line, ":", 2)
+		if len(parts) < 2 {
+			continue
+		}
+		value := strings.TrimPrefix(parts[1], " ")
+		switch parts[0] {
+		case "event":
+			event.Name = value
+		case "data":
+			if event.Data != nil {
+				event.Data = append(event.Data, '\n')
+			}
+			event.Data = append(event.Data, value...)
+		}
+	}
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	if scanner.Err() != nil {
+		return scanner.Err()
 	}
-	return data, nil
+	return io.EOF
+}
+
+func (client *WorkerClient) GetTargetImageData() ([]byte, error) {
+	return client.get("/target")
 }
 
 // GetFocusImageData returns the focus image as a png.
 func (client *WorkerClient) GetFocusImageData() ([]byte, error) {
-	resp, err := http.Get(fmt.Sprintf("%v/focus", client.endpoint))
+	resp, err := client.do("GET", "/focus", nil)
 	if err != nil {
 		return nil, err
 	}
 	defer resp.Body.Close()
-	if resp.StatusCode != http.StatusOK {
+	if resp.StatusCode == http.StatusNoContent {
 		return nil, nil
 	}
-	data, err := ioutil.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Received status code %v from server", resp.StatusCode)
+	}
+	return ioutil.ReadAll(resp.Body)
+}
+
+// get returns the body of a successful GET request
+func (client *WorkerClient) get(path string) ([]byte, error) {
+	resp, err := client.do("GET", path, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Received status code %v from server", resp.StatusCode)
+	}
+	return ioutil.ReadAll(resp.Body)
+}
+
+// do sends a signed request to the server
+func (client *WorkerClient) do(method string, path string, body []byte) (*http.Response, error) {
+	req, err := client.newRequest(method, path, body)
+	if err != nil {
+		return nil, err
+	}
+	return client.httpClient.Do(req)
+}
+
+// newRequest creates a signed request to the server
+func (client *WorkerClient) newRequest(method string, path string, body []byte) (*http.Request, error) {
+	req, err := http.NewRequest(method, client.endpoint+path, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set(workerIDHeader, client.WorkerID())
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if client.authSecret != "" {
+		SignRequest(req, body, client.authSecret)
+	}
+	return req, nil
+}
+
+// loadCertPool returns the system certificate pool with the pem encoded
+// certificates in a file added
+func loadCertPool(filename string) (*x509.CertPool, error) {
+	data, err := ioutil.ReadFile(filename)
 	if err != nil {
 		return nil, err
 	}
-	return data, nil
+	pool, err := x509.SystemCertPool()
+	if err != nil {
+		pool = x509.NewCertPool()
+	}
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("No certificates found in %v", filename)
+	}
+	return pool, nil
 }
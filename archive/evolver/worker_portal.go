@@ -1,7 +1,10 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
 	"log"
+	"net/http"
 	"time"
 )
 
@@ -11,6 +14,20 @@ import (
 // increase frequency of polls
 // Report hash mismatch as error on incoming organisms, make second call to get latest as whole organism
 
+// streamRetryDelay is how long to wait before reconnecting an interrupted
+// update stream
+const streamRetryDelay = time.Second * 5
+
+// registerRetryDelay is how long to wait before registering again after the
+// first failure. The delay doubles with every failure, up to
+// registerMaxRetryDelay.
+const registerRetryDelay = time.Second * 5
+const registerMaxRetryDelay = time.Minute * 5
+
+// streamDisconnected is the name of the event that the portal sends itself
+// when the update stream is interrupted
+const streamDisconnected = "disconnected"
+
 // A WorkerPortal serves as a way for organisms to go to and from the
 // server during the lifetime of the worker process.
 type WorkerPortal struct {
@@ -20,14 +37,84 @@ type WorkerPortal struct {
 	lastImported    *Organism
 	patchProcessor  *PatchProcessor
 	outgoingPatches []*Patch
+	stopped         chan struct{}
+
+	// heartbeats, only sent once registered
+	cpus              int
+	heartbeatInterval time.Duration
+	registerRetry     time.Duration // Delay before registering again, while unregistered
+	progressChan      chan WorkerHeartbeat
+	progress          WorkerHeartbeat
+	lastHeartbeat     time.Time
+	lastIteration     int
+
+	// streamed updates from the server, polled for when not streaming
+	streamChan chan *StreamEvent
+	streaming  bool
 }
 
 // NewWorkerPortal returns a new `WorkerPortal`
 func NewWorkerPortal(workerClient *WorkerClient) *WorkerPortal {
 	return &WorkerPortal{
-		workerClient: workerClient,
-		importQueue:  make(chan *Organism, 20),
-		exportQueue:  make(chan *Patch, 100),
+		workerClient:  workerClient,
+		importQueue:   make(chan *Organism, 20),
+		exportQueue:   make(chan *Patch, 100),
+		stopped:       make(chan struct{}),
+		progressChan:  make(chan WorkerHeartbeat, 1),
+		streamChan:    make(chan *StreamEvent, 10),
+		registerRetry: registerRetryDelay,
+	}
+}
+
+// Register registers the worker with the server, so that it shows up on the
+// roster and its patches are attributed to it. Workers that can't register
+// keep working anonymously, and try again with increasing delays once the
+// portal has started.
+func (portal *WorkerPortal) Register(cpus int) {
+	portal.cpus = cpus
+	portal.register()
+}
+
+func (portal *WorkerPortal) register() bool {
+	interval, err := portal.workerClient.Register(portal.cpus)
+	if err != nil {
+		log.Printf("Error registering with server: '%v'", err.Error())
+		return false
+	}
+	portal.heartbeatInterval = interval
+	log.Printf("Registered with server as %v", portal.workerClient.WorkerID())
+	return true
+}
+
+// retryRegistration registers the worker again, and doubles the delay until
+// the next attempt if it fails
+func (portal *WorkerPortal) retryRegistration() {
+	if portal.register() {
+		portal.lastHeartbeat = time.Now()
+		return
+	}
+	portal.registerRetry *= 2
+	if portal.registerRetry > registerMaxRetryDelay {
+		portal.registerRetry = registerMaxRetryDelay
+	}
+	log.Printf("Registering again in %v", portal.registerRetry)
+}
+
+// nextHeartbeat returns how long to wait before the next heartbeat, or before
+// trying to register again
+func (portal *WorkerPortal) nextHeartbeat() time.Duration {
+	if portal.heartbeatInterval > 0 {
+		return portal.heartbeatInterval
+	}
+	return portal.registerRetry
+}
+
+// ReportProgress records the progress of the worker for the next heartbeat.
+// It never blocks.
+func (portal *WorkerPortal) ReportProgress(iteration int, bestDiff float32) {
+	select {
+	case portal.progressChan <- WorkerHeartbeat{Iteration: iteration, BestDiff: bestDiff}:
+	default:
 	}
 }
 
@@ -38,17 +125,42 @@ func (portal *WorkerPortal) Init(topOrganism *Organism) {
 	log.Printf("Init - organism=%v", topOrganism.Hash())
 }
 
-// Start kicks off the Portal background thread
-func (portal *WorkerPortal) Start() {
+// Start kicks off the Portal background thread, which runs until the context
+// is cancelled. Done is closed once it has stopped. Top organisms are
+// streamed from the server, or polled for if the server can't stream them.
+func (portal *WorkerPortal) Start(ctx context.Context) {
+	go portal.stream(ctx)
 	go func() {
 		ticker := time.NewTicker(time.Second * time.Duration(config.SyncFrequency))
+		defer ticker.Stop()
+		// Heartbeats are sent once registered, until then registration is
+		// retried
+		heartbeat := time.NewTimer(portal.nextHeartbeat())
+		defer heartbeat.Stop()
+		portal.lastHeartbeat = time.Now()
 		for {
 			select {
+			case <-ctx.Done():
+				close(portal.stopped)
+				return
 			case <-ticker.C:
 				portal.export()
-				portal._import()
+				if !portal.streaming {
+					portal._import()
+				}
+			case event := <-portal.streamChan:
+				portal.handleStreamEvent(event)
 			case patch := <-portal.exportQueue:
 				portal.outgoingPatches = append(portal.outgoingPatches, patch)
+			case progress := <-portal.progressChan:
+				portal.progress = progress
+			case <-heartbeat.C:
+				if portal.heartbeatInterval > 0 {
+					portal.sendHeartbeat()
+				} else {
+					portal.retryRegistration()
+				}
+				heartbeat.Reset(portal.nextHeartbeat())
 			}
 			// time.Sleep(time.Second * time.Duration(config.SyncFrequency))
 			// portal.export()
@@ -66,20 +178,140 @@ func (portal *WorkerPortal) Export(organism *Organism) {
 	portal.exportQueue <- organism.Patch.Clone()
 }
 
+// Done returns a channel that is closed once the portal has stopped
+func (portal *WorkerPortal) Done() <-chan struct{} {
+	return portal.stopped
+}
+
+// Flush exports all pending organisms to the server. It must only be called
+// after the portal has stopped.
+func (portal *WorkerPortal) Flush() {
+	for {
+		select {
+		case patch := <-portal.exportQueue:
+			portal.outgoingPatches = append(portal.outgoingPatches, patch)
+		default:
+			portal.export()
+			return
+		}
+	}
+}
+
+// stream keeps the top organism stream open until the context is cancelled,
+// reconnecting when it is interrupted
+func (portal *WorkerPortal) stream(ctx context.Context) {
+	for {
+		err := portal.workerClient.StreamTopOrganism(ctx, portal.streamChan)
+		if ctx.Err() != nil {
+			return
+		}
+		if err == ErrStreamUnsupported {
+			log.Println("Server can't stream updates, polling instead")
+			return
+		}
+		log.Printf("Update stream interrupted: '%v'", err.Error())
+		select {
+		case portal.streamChan <- &StreamEvent{Name: streamDisconnected}:
+		case <-ctx.Done():
+			return
+		}
+		select {
+		case <-time.After(streamRetryDelay):
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// handleStreamEvent imports streamed top organisms. Patches are only applied
+// if they start from the last imported organism, and replaced by a full
+// import if they don't produce their target.
+func (portal *WorkerPortal) handleStreamEvent(event *StreamEvent) {
+	switch event.Name {
+	case "top":
+		portal.streaming = true
+		if portal.lastImported == nil || string(event.Data) != portal.lastImported.Hash() {
+			portal._import()
+		}
+	case "patch":
+		portal.streaming = true
+		patch := objectPool.BorrowPatch()
+		defer objectPool.ReturnPatch(patch)
+		err := json.Unmarshal(event.Data, patch)
+		if err != nil {
+			log.Printf("Error reading streamed patch: '%v'", err.Error())
+			portal._import()
+			return
+		}
+		if portal.lastImported == nil || patch.Baseline != portal.lastImported.Hash() {
+			portal._import()
+			return
+		}
+		log.Printf("Importing streamed patch %v -> %v, %v operations", patch.Baseline, patch.Target, len(patch.Operations))
+		organism := portal.patchProcessor.ProcessPatch(portal.lastImported, patch)
+		if organism.Hash() != patch.Target {
+			log.Printf("Error importing streamed organism: expected hash=%v, actual=%v", patch.Target, organism.Hash())
+			objectPool.ReturnOrganism(organism)
+			organism, err = portal.workerClient.GetTopOrganism()
+			if err != nil {
+				log.Printf("Error importing organism: '%v'", err.Error())
+				return
+			}
+			log.Printf("Full import of %v", organism.Hash())
+		}
+		portal.queueImport(organism)
+	case streamDisconnected:
+		portal.streaming = false
+	}
+}
+
+// sendHeartbeat reports the latest progress to the server, registering again
+// if the server has forgotten the worker
+func (portal *WorkerPortal) sendHeartbeat() {
+	now := time.Now()
+	heartbeat := portal.progress
+	elapsed := now.Sub(portal.lastHeartbeat).Seconds()
+	if elapsed > 0 {
+		heartbeat.IterationRate = float32(float64(heartbeat.Iteration-portal.lastIteration) / elapsed)
+	}
+	portal.lastHeartbeat = now
+	portal.lastIteration = heartbeat.Iteration
+	err := portal.workerClient.Heartbeat(&heartbeat)
+	if err == ErrUnknownWorker {
+		log.Println("Server no longer knows this worker, registering again")
+		_, err = portal.workerClient.Register(portal.cpus)
+		if err == nil {
+			log.Printf("Registered with server as %v", portal.workerClient.WorkerID())
+		}
+	}
+	if err != nil {
+		log.Printf("Error sending heartbeat: '%v'", err.Error())
+	}
+}
+
 func (portal *WorkerPortal) export() {
 	if len(portal.outgoingPatches) == 0 {
 		return
 	}
+	// Imports replace the organism the patches build on, so only the patches
+	// since the latest import follow on from each other
+	first := len(portal.outgoingPatches) - 1
+	for first > 0 && portal.outgoingPatches[first-1].Target == portal.outgoingPatches[first].Baseline {
+		first--
+	}
 	newPatch := objectPool.BorrowPatch()
-	for _, patch := range portal.outgoingPatches {
+	for _, patch := range portal.outgoingPatches[first:] {
 		newPatch.Operations = append(newPatch.Operations, patch.Operations...)
 	}
-	newPatch.Baseline = portal.outgoingPatches[0].Baseline
+	newPatch.Baseline = portal.outgoingPatches[first].Baseline
 	newPatch.Target = portal.outgoingPatches[len(portal.outgoingPatches)-1].Target
 	log.Printf("Exporting patch %v -> %v with %v operations", newPatch.Baseline, newPatch.Target, len(newPatch.Operations))
 	err := portal.workerClient.SubmitOrganism(newPatch)
 	if err != nil {
 		log.Printf("Error submitting organism to server: '%v'", err.Error())
+		if patchErr, ok := err.(*PatchError); ok && patchErr.Status == http.StatusConflict {
+			portal.resync()
+		}
 	}
 	objectPool.ReturnPatch(newPatch)
 	for _, patch := range portal.outgoingPatches {
@@ -137,19 +369,42 @@ func (portal *WorkerPortal) _import() {
 		log.Printf("Error getting organisms from server: '%v'", err.Error())
 		return
 	}
+	portal.queueImport(organism)
+}
+
+// resync replaces the organism of the worker with the top organism of the
+// server, after the server rejected a patch from the worker because it can't
+// verify it. The worker has evolved away from anything the server knows, so
+// the organism is imported even if the server's top organism hasn't changed.
+func (portal *WorkerPortal) resync() {
+	organism, err := portal.workerClient.GetTopOrganism()
+	if err != nil {
+		log.Printf("Error getting organisms from server: '%v'", err.Error())
+		return
+	}
+	log.Printf("Resyncing with server, full import of %v", organism.Hash())
+	portal.enqueueImport(organism)
+}
+
+// queueImport hands a new organism from the server to the incubator
+func (portal *WorkerPortal) queueImport(organism *Organism) {
 	if organism != nil && organism.Hash() != portal.lastImported.Hash() {
-		log.Printf("Importing organism '%v'", organism.Hash())
-		// Make a copy for the incubator
-		clone := organism.Clone()
-		select {
-		case portal.importQueue <- clone:
-			objectPool.ReturnOrganism(portal.lastImported)
-			portal.lastImported = organism
-			log.Printf("WorkerPortal: lastImported='%v'", portal.lastImported.Hash())
-		default:
-			log.Printf("Could not import, full queue")
-			objectPool.ReturnOrganism(organism)
-			objectPool.ReturnOrganism(clone)
-		}
+		portal.enqueueImport(organism)
+	}
+}
+
+func (portal *WorkerPortal) enqueueImport(organism *Organism) {
+	log.Printf("Importing organism '%v'", organism.Hash())
+	// Make a copy for the incubator
+	clone := organism.Clone()
+	select {
+	case portal.importQueue <- clone:
+		objectPool.ReturnOrganism(portal.lastImported)
+		portal.lastImported = organism
+		log.Printf("WorkerPortal: lastImported='%v'", portal.lastImported.Hash())
+	default:
+		log.Printf("Could not import, full queue")
+		objectPool.ReturnOrganism(organism)
+		objectPool.ReturnOrganism(clone)
 	}
 }
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"sort"
+	"time"
+)
+
+// workerHeartbeatInterval is how often registered workers report their progress
+const workerHeartbeatInterval = time.Second * 10
+
+// workerStaleTimeout is how long a worker can go without contacting the
+// server before it is considered stale
+const workerStaleTimeout = workerHeartbeatInterval * 3
+
+// workerForgetTimeout is how long a stale worker stays on the roster
+const workerForgetTimeout = time.Hour
+
+const (
+	// WorkerActive - the worker has contacted the server recently
+	WorkerActive = "active"
+	// WorkerStale - the worker hasn't been heard from for a while
+	WorkerStale = "stale"
+)
+
+// WorkerRegistration is sent by a worker to register with the server
+type WorkerRegistration struct {
+	Hostname string
+	CPUs     int
+}
+
+// WorkerRegistered is the server's response to a registration
+type WorkerRegistered struct {
+	ID               string
+	HeartbeatSeconds int // How often the worker should send heartbeats
+}
+
+// A WorkerHeartbeat reports the progress of a worker
+type WorkerHeartbeat struct {
+	Iteration     int
+	IterationRate float32 // Iterations per second since the previous heartbeat
+	BestDiff      float32
+}
+
+// WorkerStatus is an entry in the worker roster
+type WorkerStatus struct {
+	ID            string
+	Hostname      string
+	Address       string
+	CPUs          int
+	Registered    time.Time // Zero for workers that submitted patches without registering
+	LastSeen      time.Time
+	Status        string
+	Iteration     int
+	IterationRate float32
+	BestDiff      float32
+	Accepted      int // Number of patches that passed validation
+	Rejected      int // Number of patches that were rejected
+}
+
+// A WorkerRoster keeps track of the workers contributing to the server. It
+// is not safe for concurrent use.
+type WorkerRoster struct {
+	workers map[string]*WorkerStatus
+}
+
+// NewWorkerRoster returns a new, empty WorkerRoster
+func NewWorkerRoster() *WorkerRoster {
+	roster := new(WorkerRoster)
+	roster.workers = map[string]*WorkerStatus{}
+	return roster
+}
+
+// Register adds a worker to the roster under a new id
+func (roster *WorkerRoster) Register(registration *WorkerRegistration, address string) *WorkerStatus {
+	id := ""
+	for id == "" || roster.workers[id] != nil {
+		id = fmt.Sprintf("w%08x", rand.Uint32())
+	}
+	now := time.Now()
+	worker := &WorkerStatus{
+		ID:         id,
+		Hostname:   registration.Hostname,
+		Address:    address,
+		CPUs:       registration.CPUs,
+		Registered: now,
+		LastSeen:   now,
+		Status:     WorkerActive,
+		BestDiff:   -1,
+	}
+	roster.workers[id] = worker
+	return worker
+}
+
+// Heartbeat records the progress of a registered worker. It returns false if
+// the worker isn't registered.
+func (roster *WorkerRoster) Heartbeat(id string, heartbeat *WorkerHeartbeat) bool {
+	worker, has := roster.workers[id]
+	if !has || worker.Registered.IsZero() {
+		return false
+	}
+	worker.LastSeen = time.Now()
+	worker.Iteration = heartbeat.Iteration
+	worker.IterationRate = heartbeat.IterationRate
+	worker.BestDiff = heartbeat.BestDiff
+	return true
+}
+
+// RecordSubmission attributes a patch to the worker that submitted it. Workers
+// that haven't registered are added to the roster under the name they were
+// identified by.
+func (roster *WorkerRoster) RecordSubmission(id string, address string, accepted bool) *WorkerStatus {
+	worker, has := roster.workers[id]
+	if !has {
+		worker = &WorkerStatus{
+			ID:       id,
+			Address:  address,
+			BestDiff: -1,
+		}
+		roster.workers[id] = worker
+	}
+	worker.LastSeen = time.Now()
+	if accepted {
+		worker.Accepted++
+	} else {
+		worker.Rejected++
+	}
+	return worker
+}
+
+// List returns the workers on the roster, ordered by id. Workers that have
+// been stale for too long are forgotten.
+func (roster *WorkerRoster) List() []WorkerStatus {
+	workers := make([]WorkerStatus, 0, len(roster.workers))
+	for id, worker := range roster.workers {
+		since := time.Since(worker.LastSeen)
+		if since > workerForgetTimeout {
+			delete(roster.workers, id)
+			continue
+		}
+		worker.Status = WorkerActive
+		if since > workerStaleTimeout {
+			worker.Status = WorkerStale
+		}
+		workers = append(workers, *worker)
+	}
+	sort.Slice(workers, func(i int, j int) bool {
+		return workers[i].ID < workers[j].ID
+	})
+	return workers
+}